@@ -0,0 +1,31 @@
+package vcr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Benchmark serves every request in the cassette at name to handler b.N times, so that recorded traffic can
+// be used as benchmark input. Responses are neither compared nor recorded and the cassette is only read once.
+func Benchmark(b *testing.B, name string, handler http.Handler, opts ...Option) {
+	b.Helper()
+
+	cfg := newConfig(opts)
+	handler = withMiddleware(handler, cfg)
+
+	tape, err := Load(name)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, interaction := range tape.Interactions {
+			request, err := newRequest(interaction, cfg)
+			require.NoError(b, err)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestBenchmark(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "Hello world!", 200)
+	})
+
+	result := testing.Benchmark(func(b *testing.B) {
+		vcr.Benchmark(b, "vcr_test.yml", mux)
+	})
+	require.Positive(t, result.N)
+	require.GreaterOrEqual(t, calls, result.N)
+}
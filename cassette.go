@@ -0,0 +1,611 @@
+package vcr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Response is a recorded response.
+type Response struct {
+	Status struct {
+		Code    int     `yaml:"code"`
+		Message *string `yaml:"message"`
+	} `yaml:"status"`
+	Headers     http.Header `yaml:"headers"`
+	Body        Body        `yaml:"body"`
+	HttpVersion *string     `yaml:"http_version"`
+	// Chunks holds the size of each chunk flushed by a streaming handler. It is not compared.
+	Chunks []int `yaml:"chunks,omitempty"`
+	// HeaderOrder holds the order the handler added its headers in. It is only recorded and compared
+	// with OrderedHeaders.
+	HeaderOrder []string `yaml:"header_order,omitempty"`
+	// Informational holds the interim 1xx responses, such as 103 Early Hints, sent before the final response
+	// in the order they were sent. They are only recorded and compared with Capture1xx.
+	Informational []Informational `yaml:"informational,omitempty"`
+	// Hijacked marks a response whose body is the raw bytes the handler wrote to the connection after hijacking
+	// it, recorded with RecordHijacked.
+	Hijacked bool `yaml:"hijacked,omitempty"`
+}
+
+// Informational is an interim 1xx response.
+type Informational struct {
+	Code    int         `yaml:"code"`
+	Headers http.Header `yaml:"headers"`
+}
+
+// Body is a request or response body. Bodies that are not valid UTF-8 are stored base64 encoded.
+type Body struct {
+	Encoding string `yaml:"encoding,omitempty"`
+	String   string `yaml:"string"`
+	// File is set when the body is stored in a separate file, relative to the cassette.
+	File string `yaml:"file,omitempty"`
+
+	// compressed is set for bodies that were loaded from a GZIP encoding, so that they are written back in one.
+	compressed bool
+	// base64URL is set for bodies that were loaded from a BASE64URL encoding, so that they are written back in one.
+	base64URL bool
+}
+
+const (
+	encodingUTF8   = "UTF-8"
+	encodingBase64 = "BASE64"
+	encodingSHA256 = "SHA256"
+	encodingGzip   = "GZIP"
+)
+
+// encodingBase64URL is the URL-safe base64 alphabet without padding.
+const encodingBase64URL = "BASE64URL"
+
+// newBody stores s in a form that is safe to write to YAML.
+func newBody(s string) Body {
+	if utf8.ValidString(s) {
+		return Body{Encoding: encodingUTF8, String: s}
+	}
+	return Body{Encoding: encodingBase64, String: base64.StdEncoding.EncodeToString([]byte(s))}
+}
+
+// newHashedBody stores only the SHA-256 of s, for bodies that are too large to be worth keeping.
+func newHashedBody(s string) Body {
+	sum := sha256.Sum256([]byte(s))
+	return Body{Encoding: encodingSHA256, String: hex.EncodeToString(sum[:])}
+}
+
+// newGzipHashedBody stores only the SHA-256 of the decompressed contents of the gzipped body, decompressing it as
+// it is hashed.
+func newGzipHashedBody(body string) (Body, error) {
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		return Body{}, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, zr); err != nil {
+		return Body{}, err
+	}
+	return Body{Encoding: encodingSHA256, String: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// isText reports whether the Content-Type in h is a textual media type.
+func isText(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || isJSON(h) || strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/javascript" || mediaType == "application/x-www-form-urlencoded"
+}
+
+// decode returns the raw contents of the body. Bodies stored as a hash cannot be decoded.
+func (b Body) decode() (string, error) {
+	if strings.EqualFold(b.Encoding, encodingSHA256) {
+		return "", fmt.Errorf("body is stored as a %s hash", encodingSHA256)
+	}
+	if strings.EqualFold(b.Encoding, encodingGzip) {
+		return decompress(b.String)
+	}
+	if strings.EqualFold(b.Encoding, encodingBase64) {
+		decoded, err := base64.StdEncoding.DecodeString(b.String)
+		return string(decoded), err
+	}
+	if strings.EqualFold(b.Encoding, encodingBase64URL) {
+		decoded, err := base64.RawURLEncoding.DecodeString(b.String)
+		return string(decoded), err
+	}
+	return b.String, nil
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method  string      `yaml:"method"`
+	URI     string      `yaml:"uri"`
+	Body    *Body       `yaml:"body,omitempty"`
+	Headers http.Header `yaml:"headers"`
+	// Form is the parsed view of a form body. When Body is also set it is sent verbatim and must decode to the
+	// same values, otherwise the body is made by encoding Form.
+	Form url.Values `yaml:"form,omitempty"`
+	// Path, with any query, is sent to the handler in place of those of URI, for cassettes recorded against a
+	// handler mounted under a prefix.
+	Path string `yaml:"path,omitempty"`
+	// RemoteAddr overrides the address set with WithRemoteAddr for this request.
+	RemoteAddr string `yaml:"remote_addr,omitempty"`
+	// Trailers are sent after the body of a chunked request.
+	Trailers http.Header `yaml:"trailers,omitempty"`
+	// Cookies are sent in the Cookie header, in order of name, after any recorded in Headers.
+	Cookies map[string]string `yaml:"cookies,omitempty"`
+	// Variants are other inputs that must produce the same response, once normalized, as the request itself.
+	// Each is replayed after the request and compared against its recorded response, but never recorded.
+	Variants []RequestVariant `yaml:"variants,omitempty"`
+}
+
+// RequestVariant changes the query or body of a request to give another input for the same recorded response.
+type RequestVariant struct {
+	// Query sets the named query parameters, replacing those recorded in the URI.
+	Query url.Values `yaml:"query,omitempty"`
+	// Body replaces the recorded body and form.
+	Body *Body `yaml:"body,omitempty"`
+}
+
+// apply returns a copy of r with the changes of variant.
+func (variant RequestVariant) apply(r Request) (Request, error) {
+	if len(variant.Query) > 0 {
+		uri, err := url.Parse(r.URI)
+		if err != nil {
+			return r, err
+		}
+		query := uri.Query()
+		for key, values := range variant.Query {
+			query[key] = values
+		}
+		uri.RawQuery = query.Encode()
+		r.URI = uri.String()
+	}
+	if variant.Body != nil {
+		r.Body = variant.Body
+		r.Form = nil
+	}
+	r.Variants = nil
+	return r, nil
+}
+
+// Interaction is a recorded request and the response it produced.
+type Interaction struct {
+	Request    Request   `yaml:"request"`
+	Response   *Response `yaml:"response"`
+	RecordedAt string    `yaml:"recorded_at"`
+	// Tags label the interaction so that WithTags can select it.
+	Tags []string `yaml:"tags,omitempty"`
+	// DelayMs is how long to wait before serving the request when replaying WithDelays.
+	DelayMs int `yaml:"delay_ms,omitempty"`
+	// RecordedBy is the test that last recorded the response, when overwritten WithRecordedBy.
+	RecordedBy string `yaml:"recorded_by,omitempty"`
+	// Log is what the handler logged while the response was recorded, with WithCaptureWriter. It is not compared.
+	Log string `yaml:"log,omitempty"`
+	// AssertHeaders are the only response headers that matter for the interaction. The handler must send them
+	// with exactly these values, and other headers are neither compared nor recorded.
+	AssertHeaders http.Header `yaml:"assert_headers,omitempty"`
+	// Variants are other representations of the response, which the Replayer serves instead of Response to
+	// requests that accept them.
+	Variants []Variant `yaml:"variants,omitempty"`
+}
+
+// Variant is the response recorded for requests that accept a particular media type, such as
+// application/xml, from an endpoint that negotiates its content. Its body is always stored inline.
+type Variant struct {
+	Accept   string    `yaml:"accept"`
+	Response *Response `yaml:"response"`
+}
+
+// Cassette is a recording of a series of interactions.
+type Cassette struct {
+	Interactions []*Interaction `yaml:"http_interactions"`
+	RecordedWith string         `yaml:"recorded_with"`
+	// Normalize declares normalization that applies whenever the cassette is replayed, except with Streaming.
+	Normalize *Normalization `yaml:"normalize,omitempty"`
+}
+
+// Normalization is the declarative form of common normalize options, stored in a cassette so that every test
+// replaying it compares responses in the same way.
+type Normalization struct {
+	ReplaceUUIDs      bool     `yaml:"replace_uuids,omitempty"`
+	ReplaceTimestamps bool     `yaml:"replace_timestamps,omitempty"`
+	IgnoreHeaders     []string `yaml:"ignore_headers,omitempty"`
+	SortJSONArrays    []string `yaml:"sort_json_arrays,omitempty"`
+}
+
+// options returns the normalize options n declares.
+func (n *Normalization) options() []NormalizeOption {
+	if n == nil {
+		return nil
+	}
+	var opts []NormalizeOption
+	if n.ReplaceUUIDs {
+		opts = append(opts, ReplaceUUIDs)
+	}
+	if n.ReplaceTimestamps {
+		opts = append(opts, ReplaceTimestamps)
+	}
+	if len(n.IgnoreHeaders) > 0 {
+		opts = append(opts, IgnoreHeaders(n.IgnoreHeaders...))
+	}
+	if len(n.SortJSONArrays) > 0 {
+		opts = append(opts, SortJSONArrays(n.SortJSONArrays...))
+	}
+	return opts
+}
+
+func open(r io.Reader) (*Cassette, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	var tape Cassette
+	if err := decoder.Decode(&tape); err != nil {
+		return nil, err
+	}
+	return &tape, nil
+}
+
+func encode(w io.Writer, c *Cassette) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	return encoder.Encode(c)
+}
+
+// encodeWithComments encodes c like encode, keeping the comments from original on every node that is
+// still present so that hand-written annotations survive an overwrite.
+func encodeWithComments(w io.Writer, c *Cassette, original *yaml.Node) error {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return err
+	}
+	if original != nil && len(original.Content) > 0 {
+		copyComments(original.Content[0], &node)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	return encoder.Encode(&node)
+}
+
+// copyComments copies the comments of from onto to, recursing into mapping values with the same key and
+// sequence items at the same index.
+func copyComments(from, to *yaml.Node) {
+	if from.Kind != to.Kind {
+		return
+	}
+	to.HeadComment, to.LineComment, to.FootComment = from.HeadComment, from.LineComment, from.FootComment
+	switch to.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(to.Content); i += 2 {
+			for j := 0; j+1 < len(from.Content); j += 2 {
+				if from.Content[j].Value == to.Content[i].Value {
+					copyComments(from.Content[j], to.Content[i])
+					copyComments(from.Content[j+1], to.Content[i+1])
+					break
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		for i := 0; i < len(to.Content) && i < len(from.Content); i++ {
+			copyComments(from.Content[i], to.Content[i])
+		}
+	}
+}
+
+// stripBanner removes the comments before the document start marker, where the banner is written, so that
+// it is not kept as a comment when the cassette is rewritten with a new one.
+func stripBanner(contents []byte) []byte {
+	rest := contents
+	for len(rest) > 0 {
+		line, next, _ := bytes.Cut(rest, []byte("\n"))
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case bytes.Equal(trimmed, []byte("---")):
+			return next
+		case len(trimmed) > 0 && trimmed[0] != '#':
+			return contents
+		}
+		rest = next
+	}
+	return contents
+}
+
+// RecordedRequest describes a request to add to a cassette.
+type RecordedRequest struct {
+	Method  string
+	URI     string
+	Body    string
+	Headers http.Header
+}
+
+// toRequest converts r into the form stored in a cassette.
+func (r RecordedRequest) toRequest() Request {
+	request := Request{
+		Method:  strings.ToLower(r.Method),
+		URI:     r.URI,
+		Headers: r.Headers,
+	}
+	if request.Headers == nil {
+		request.Headers = http.Header{}
+	}
+	if r.Body != "" {
+		body := newBody(r.Body)
+		request.Body = &body
+	}
+	return request
+}
+
+// Skeleton returns a cassette containing requests with no responses. Writing it to a file and running
+// Replay with -overwrite records the responses.
+func Skeleton(requests []RecordedRequest) []byte {
+	tape := &Cassette{}
+	for _, r := range requests {
+		tape.Interactions = append(tape.Interactions, &Interaction{Request: r.toRequest()})
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, tape); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Load reads the cassette at path.
+func Load(path string) (*Cassette, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	tape, err := open(fd)
+	if err != nil {
+		return nil, err
+	}
+	return tape, readBodies(filepath.Dir(path), tape)
+}
+
+// LoadLenient reads the cassette at path, skipping any interactions that cannot be parsed and returning
+// their errors alongside the interactions that could. It is meant for tools that repair cassettes; Replay
+// always loads cassettes strictly.
+func LoadLenient(path string) (*Cassette, []error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var doc struct {
+		Interactions []yaml.Node `yaml:"http_interactions"`
+		RecordedWith string      `yaml:"recorded_with"`
+		Normalize    yaml.Node   `yaml:"normalize"`
+	}
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return nil, []error{err}
+	}
+
+	tape := &Cassette{RecordedWith: doc.RecordedWith}
+	var errs []error
+	if doc.Normalize.Kind != 0 {
+		var normalize Normalization
+		if err := decodeNode(&doc.Normalize, &normalize); err != nil {
+			errs = append(errs, fmt.Errorf("normalize at line %d: %w", doc.Normalize.Line, err))
+		} else {
+			tape.Normalize = &normalize
+		}
+	}
+	for i := range doc.Interactions {
+		node := &doc.Interactions[i]
+		var interaction Interaction
+		if err := decodeNode(node, &interaction); err != nil {
+			errs = append(errs, fmt.Errorf("interaction %d at line %d: %w", i, node.Line, err))
+			continue
+		}
+		tape.Interactions = append(tape.Interactions, &interaction)
+	}
+	if err := readBodies(filepath.Dir(path), tape); err != nil {
+		errs = append(errs, err)
+	}
+	return tape, errs
+}
+
+// decodeNode decodes node into v, rejecting unknown fields in the same way as open.
+func decodeNode(node *yaml.Node, v any) error {
+	encoded, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(encoded))
+	decoder.KnownFields(true)
+	return decoder.Decode(v)
+}
+
+// compress returns s gzipped and base64 encoded.
+func compress(s string) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decompress reverses compress.
+func decompress(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		return "", err
+	}
+	contents, err := io.ReadAll(zr)
+	return string(contents), err
+}
+
+// readBodies loads the response bodies that tape stores in files relative to dir, decompresses
+// those stored with the GZIP encoding and decodes those stored with the BASE64URL encoding.
+func readBodies(dir string, tape *Cassette) error {
+	for _, interaction := range tape.Interactions {
+		if interaction.Response == nil {
+			continue
+		}
+		if body := &interaction.Response.Body; strings.EqualFold(body.Encoding, encodingGzip) {
+			contents, err := body.decode()
+			if err != nil {
+				return err
+			}
+			*body = newBody(contents)
+			body.compressed = true
+			continue
+		}
+		if body := &interaction.Response.Body; strings.EqualFold(body.Encoding, encodingBase64URL) {
+			contents, err := body.decode()
+			if err != nil {
+				return err
+			}
+			*body = newBody(contents)
+			body.base64URL = true
+			continue
+		}
+		if interaction.Response.Body.File == "" {
+			continue
+		}
+		file := interaction.Response.Body.File
+		contents, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		interaction.Response.Body = newBody(string(contents))
+		interaction.Response.Body.File = file
+	}
+	return nil
+}
+
+// writeBodies moves response bodies larger than the configured threshold into files relative to dir,
+// named after their contents, and compresses those over the compression threshold. Bodies that were
+// loaded from a file, compressed or with the BASE64URL encoding stay that way.
+func writeBodies(dir string, tape *Cassette, cfg *config) error {
+	for _, interaction := range tape.Interactions {
+		if interaction.Response == nil {
+			continue
+		}
+		body := &interaction.Response.Body
+		if strings.EqualFold(body.Encoding, encodingSHA256) {
+			// a hash is small enough to always be kept in the cassette
+			body.File = ""
+			continue
+		}
+		contents, err := body.decode()
+		if err != nil {
+			return err
+		}
+		external := body.File != ""
+		if cfg.externalBodies {
+			external = len(contents) > cfg.bodyThreshold
+		}
+		if !external {
+			body.File = ""
+			compressed := body.compressed
+			if cfg.compressBodies {
+				compressed = len(contents) > cfg.compressThreshold
+			}
+			if compressed {
+				encoded, err := compress(contents)
+				if err != nil {
+					return err
+				}
+				*body = Body{Encoding: encodingGzip, String: encoded}
+			} else if body.Encoding == encodingBase64 && (cfg.base64URL || body.base64URL) {
+				*body = Body{Encoding: encodingBase64URL, String: base64.RawURLEncoding.EncodeToString([]byte(contents))}
+			}
+			continue
+		}
+
+		bodyDir := cfg.bodyDir
+		if !cfg.externalBodies {
+			bodyDir = path.Dir(body.File)
+		}
+		sum := sha256.Sum256([]byte(contents))
+		file := path.Join(bodyDir, hex.EncodeToString(sum[:8])+".bin")
+
+		target := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, []byte(contents), 0o644); err != nil {
+			return err
+		}
+		*body = Body{File: file}
+	}
+	return nil
+}
+
+// Save writes c to path, replacing any existing file.
+func Save(path string, c *Cassette) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(fd, c); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// SortInteractions makes -overwrite, and Recorder.Save, order the interactions of a cassette by request method
+// and then URI, keeping the order of interactions with the same method and URI, so that the committed file does
+// not depend on the order the requests were made in. Only use it for cassettes whose order does not matter.
+// It has no effect with Streaming.
+func SortInteractions() ReplayOption {
+	return func(c *config) {
+		c.sortInteractions = true
+	}
+}
+
+// sortInteractions orders the interactions of tape by method and URI.
+func sortInteractions(tape *Cassette) {
+	sort.SliceStable(tape.Interactions, func(i, j int) bool {
+		a, b := tape.Interactions[i].Request, tape.Interactions[j].Request
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.URI < b.URI
+	})
+}
+
+// Compact collapses runs of consecutive interactions with identical requests and responses into the first
+// interaction of each run. Responses are compared after applying opts. This changes the meaning of cassettes
+// that deliberately repeat a request, such as polling, so it must be applied explicitly.
+func Compact(c *Cassette, opts ...Option) {
+	cfg := newConfig(opts)
+	var compacted []*Interaction
+	for _, next := range c.Interactions {
+		if n := len(compacted); n > 0 {
+			previous := compacted[n-1]
+			if reflect.DeepEqual(previous.Request, next.Request) && !isResponseModified(previous.Response, next.Response, cfg) {
+				continue
+			}
+		}
+		compacted = append(compacted, next)
+	}
+	c.Interactions = compacted
+}
@@ -0,0 +1,110 @@
+package vcr_test
+
+import (
+	"flag"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	poll := func(body string) *vcr.Interaction {
+		interaction := &vcr.Interaction{Response: &vcr.Response{}}
+		interaction.Request.Method = "get"
+		interaction.Request.URI = "http://localhost/status"
+		interaction.Response.Status.Code = 200
+		interaction.Response.Body.String = body
+		return interaction
+	}
+
+	tape := &vcr.Cassette{Interactions: []*vcr.Interaction{
+		poll("pending 11111111-2222-4333-8444-555555555555"),
+		poll("pending 66666666-7777-4888-9999-000000000000"),
+		poll("done"),
+		poll("pending"),
+	}}
+
+	path := filepath.Join(t.TempDir(), "cassette.yml")
+	require.NoError(t, vcr.Save(path, tape))
+	loaded, err := vcr.Load(path)
+	require.NoError(t, err)
+
+	vcr.Compact(loaded, vcr.ReplaceUUIDs)
+	require.Len(t, loaded.Interactions, 3)
+	require.Equal(t, "done", loaded.Interactions[1].Response.Body.String)
+}
+
+func TestSkeleton(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "skeleton.yml")
+	require.NoError(t, os.WriteFile(path, vcr.Skeleton([]vcr.RecordedRequest{
+		{Method: "GET", URI: "http://localhost/hello-world"},
+		{Method: "POST", URI: "http://localhost/hello-world", Body: "hi"},
+	}), 0o644))
+
+	require.NoError(t, flag.Set("overwrite", "true"))
+	defer func() {
+		require.NoError(t, flag.Set("overwrite", "false"))
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Hello world!", 200)
+	})
+	vcr.Replay(t, path, mux)
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	require.Len(t, tape.Interactions, 2)
+	for _, interaction := range tape.Interactions {
+		require.Equal(t, 200, interaction.Response.Status.Code)
+		require.NotEmpty(t, interaction.RecordedAt)
+	}
+	require.Equal(t, "hi", tape.Interactions[1].Request.Body.String)
+}
+
+func TestLoadLenient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`http_interactions:
+  - request:
+      method: get
+      uri: http://localhost/a
+      headers: {}
+    response: null
+    recorded_at: ""
+  - request:
+      method: get
+      uri: http://localhost/b
+      hedaers: {}
+    response: null
+    recorded_at: ""
+recorded_with: ""
+`), 0o644))
+
+	_, err := vcr.Load(path)
+	require.Error(t, err)
+
+	tape, errs := vcr.LoadLenient(path)
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "interaction 1 at line 8")
+	require.Len(t, tape.Interactions, 1)
+	require.Equal(t, "http://localhost/a", tape.Interactions[0].Request.URI)
+}
+
+func TestLoadLenientNormalize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normalize.yml")
+	want := &vcr.Normalization{ReplaceUUIDs: true, IgnoreHeaders: []string{"Date"}, SortJSONArrays: []string{"$.items"}}
+	require.NoError(t, vcr.Save(path, &vcr.Cassette{Normalize: want}))
+
+	// a repair tool saves what it could load, which must keep the normalization of the cassette
+	tape, errs := vcr.LoadLenient(path)
+	require.Empty(t, errs)
+	require.Equal(t, want, tape.Normalize)
+	require.NoError(t, vcr.Save(path, tape))
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, want, tape.Normalize)
+}
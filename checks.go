@@ -0,0 +1,429 @@
+package vcr
+
+import (
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// NoNewHeaders fails a replay, rather than recording the change, when the handler returns a header that is
+// missing from the recorded response. It has no effect with -overwrite.
+func NoNewHeaders() ReplayOption {
+	return func(c *config) {
+		c.noNewHeaders = true
+	}
+}
+
+// checkNewHeaders fails if recording has headers that are absent from the recorded response.
+func checkNewHeaders(t *testing.T, i int, r *http.Request, recorded *Response, recording *Response) {
+	t.Helper()
+	if recorded == nil {
+		return
+	}
+	var added []string
+	for name := range recording.Headers {
+		if _, ok := recorded.Headers[http.CanonicalHeaderKey(name)]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	require.Emptyf(t, added, "interaction %d: %s %s returned headers that are not in the recording: %v", i, r.Method, r.URL.Path, added)
+}
+
+// RequireBodyConsumed fails a replay if the handler returns without reading the whole request body, which
+// stops the connection being reused in production.
+func RequireBodyConsumed() ReplayOption {
+	return func(c *config) {
+		c.bodyConsumed = true
+	}
+}
+
+// checkBodyConsumed fails if body, the request body given to the handler, has unread bytes.
+func checkBodyConsumed(t *testing.T, i int, r *http.Request, body io.Reader) {
+	t.Helper()
+	if body == nil {
+		return
+	}
+	unread, err := io.Copy(io.Discard, body)
+	require.NoError(t, err)
+	require.Zerof(t, unread, "interaction %d: %s %s left %d bytes of the request body unread", i, r.Method, r.URL.Path, unread)
+}
+
+// HeaderSubset only compares the headers that are in the recorded response, so that a handler may add headers
+// without the replay failing. With -overwrite a changed response is still recorded with all of its headers.
+func HeaderSubset() ReplayOption {
+	return func(c *config) {
+		c.headerSubset = true
+	}
+}
+
+// RequireHeaderPresent fails a replay when the handler leaves out any of the named response headers, but treats
+// their values as unchanged when both the recording and the response have them, for headers such as
+// X-Frame-Options whose value can vary by environment. Presence is checked before normalization, so a header can
+// be required and left out of the comparison with IgnoreHeaders.
+func RequireHeaderPresent(names ...string) ReplayOption {
+	return func(c *config) {
+		for _, name := range names {
+			c.requiredHeaders = append(c.requiredHeaders, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// checkHeadersPresent fails if recording does not have every one of the named headers.
+func checkHeadersPresent(t *testing.T, i int, r *http.Request, recording *Response, names []string) {
+	t.Helper()
+	for _, name := range names {
+		if _, ok := recording.Headers[name]; !ok {
+			require.Failf(t, "missing header", "interaction %d: %s %s did not set the required %s header", i, r.Method, r.URL.Path, name)
+		}
+	}
+}
+
+// checkAssertedHeaders fails unless recording has each of the asserted headers with exactly the asserted values.
+func checkAssertedHeaders(t *testing.T, i int, r *http.Request, asserted http.Header, recording *Response) {
+	t.Helper()
+	for name, values := range asserted {
+		require.Equalf(t, values, recording.Headers.Values(name), "interaction %d: %s %s returned the wrong %s header", i, r.Method, r.URL.Path, http.CanonicalHeaderKey(name))
+	}
+}
+
+// onlyHeaders returns the headers of h named in names.
+func onlyHeaders(h http.Header, names http.Header) http.Header {
+	only := http.Header{}
+	for name := range names {
+		if values := h.Values(name); len(values) > 0 {
+			only[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return only
+}
+
+// NumericHeaderTolerance treats the named header as unchanged when its recorded and actual values are numbers
+// no more than delta apart, for headers such as X-RateLimit-Remaining that drift on every run. The header must
+// still be present with the same number of values.
+func NumericHeaderTolerance(name string, delta float64) ReplayOption {
+	return func(c *config) {
+		if c.headerTolerance == nil {
+			c.headerTolerance = map[string]float64{}
+		}
+		c.headerTolerance[http.CanonicalHeaderKey(name)] = delta
+	}
+}
+
+// withinTolerance reports whether each of the actual values is a number within delta of the recorded one.
+func withinTolerance(recorded []string, actual []string, delta float64) bool {
+	if len(recorded) != len(actual) {
+		return false
+	}
+	for i := range recorded {
+		expected, err := strconv.ParseFloat(strings.TrimSpace(recorded[i]), 64)
+		if err != nil {
+			return false
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(actual[i]), 64)
+		if err != nil || math.Abs(expected-value) > delta {
+			return false
+		}
+	}
+	return true
+}
+
+// WarnContentTypeMismatch logs interactions whose response body is JSON but whose Content-Type is not a JSON
+// media type, or the reverse, which usually points to a content negotiation bug in the handler.
+func WarnContentTypeMismatch() ReplayOption {
+	return func(c *config) {
+		c.warnContentType = true
+	}
+}
+
+// checkContentType logs when the body of recording disagrees with its declared Content-Type.
+func checkContentType(t *testing.T, i int, r *http.Request, recording *Response) {
+	t.Helper()
+	body, err := recording.Body.decode()
+	if err != nil || strings.TrimSpace(body) == "" {
+		return
+	}
+	declared, detected := isJSON(recording.Headers), looksLikeJSON(body)
+	switch {
+	case detected && !declared:
+		t.Logf("interaction %d: %s %s returned a JSON body with Content-Type %q", i, r.Method, r.URL.Path, recording.Headers.Get("Content-Type"))
+	case declared && !detected:
+		t.Logf("interaction %d: %s %s returned Content-Type %q but the body is not JSON", i, r.Method, r.URL.Path, recording.Headers.Get("Content-Type"))
+	}
+}
+
+// AssertContentCategory fails a replay when the response Content-Type falls into a different category than the
+// recorded one, whatever the normalize options, such as a handler that used to return JSON now returning an HTML
+// login page. The categories are json, xml, html, text, binary and none for a response without a Content-Type.
+func AssertContentCategory() ReplayOption {
+	return func(c *config) {
+		c.contentCategory = true
+	}
+}
+
+// checkContentCategory fails if the Content-Type of recording is in a different category than that of recorded.
+func checkContentCategory(t *testing.T, i int, r *http.Request, recorded, recording *Response) {
+	t.Helper()
+	expected, actual := contentCategory(recorded.Headers), contentCategory(recording.Headers)
+	require.Equalf(t, expected, actual, "interaction %d: %s %s returned %s with Content-Type %q but the recording is %s", i, r.Method, r.URL.Path, actual, recording.Headers.Get("Content-Type"), expected)
+}
+
+// contentCategory classifies the Content-Type of h for AssertContentCategory.
+func contentCategory(h http.Header) string {
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		return "none"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	switch {
+	case err != nil:
+		return "binary"
+	case mediaType == "text/html", mediaType == "application/xhtml+xml":
+		return "html"
+	case isJSON(h):
+		return "json"
+	case strings.HasSuffix(mediaType, "xml"):
+		return "xml"
+	case isText(h):
+		return "text"
+	default:
+		return "binary"
+	}
+}
+
+// CheckContentLength fails a replay when the Content-Length a handler declares does not match the length of
+// the body it writes. Cassettes always record the actual length, which would otherwise hide the mistake.
+func CheckContentLength() ReplayOption {
+	return func(c *config) {
+		c.checkContentLength = true
+	}
+}
+
+// checkContentLength fails if the Content-Length set by the handler disagrees with the body in recorder.
+func checkContentLength(t *testing.T, i int, r *http.Request, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	declared := recorder.Result().Header.Get("Content-Length")
+	if declared == "" {
+		return
+	}
+	require.Equalf(t, declared, strconv.Itoa(recorder.Body.Len()), "interaction %d: %s %s declared a Content-Length of %s but wrote %d bytes", i, r.Method, r.URL.Path, declared, recorder.Body.Len())
+}
+
+// RejectDuplicateJSONKeys fails a replay when a JSON response has an object with the same key more than once.
+// Decoding keeps only the last value, so the duplicate would otherwise be hidden by normalization.
+func RejectDuplicateJSONKeys() ReplayOption {
+	return func(c *config) {
+		c.duplicateKeys = true
+	}
+}
+
+// checkDuplicateKeys fails if the JSON body in recorder repeats a key within an object.
+func checkDuplicateKeys(t *testing.T, i int, r *http.Request, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	body := recorder.Body.String()
+	if !isJSON(recorder.Result().Header) && !looksLikeJSON(body) {
+		return
+	}
+	key, found := duplicateJSONKey(body)
+	require.Falsef(t, found, "interaction %d: %s %s returned JSON with the duplicate key %s", i, r.Method, r.URL.Path, key)
+}
+
+// AssertIdempotent serves each interaction a second time and fails a replay if the two responses differ after
+// normalization, catching handlers whose output changes between identical requests before it reaches a cassette.
+func AssertIdempotent() ReplayOption {
+	return func(c *config) {
+		c.idempotent = true
+	}
+}
+
+// checkRequestVariants serves each of the request variants of interaction with handler and fails if the response
+// to any of them differs from the recorded response.
+func checkRequestVariants(t *testing.T, i int, handler http.Handler, interaction *Interaction, cfg *config, isModified func(before *Response, after *Response) bool) {
+	t.Helper()
+	if cfg.freshHandler != nil {
+		handler = withMiddleware(cfg.freshHandler(), cfg)
+	}
+	recorded := interaction.Response
+	if len(interaction.AssertHeaders) > 0 && recorded != nil {
+		recorded = &Response{}
+		*recorded = *interaction.Response
+		recorded.Headers = onlyHeaders(recorded.Headers, interaction.AssertHeaders)
+	}
+	for j, variant := range interaction.Request.Variants {
+		varied := *interaction
+		var err error
+		varied.Request, err = variant.apply(interaction.Request)
+		require.NoErrorf(t, err, "interaction %d: variant %d", i, j)
+		request, err := newRequest(&varied, cfg)
+		require.NoErrorf(t, err, "interaction %d: variant %d", i, j)
+		request = withInteraction(request, i, interaction)
+		request, cancel := withDeadline(request, cfg)
+		recorder := httptest.NewRecorder()
+		serveInteraction(t, i, handler, &informationalRecorder{ResponseWriter: recorder}, request, cfg.timeout)
+		cancel()
+		recording := newRecording(request, recorder, cfg)
+		if len(interaction.AssertHeaders) > 0 {
+			recording.Headers = onlyHeaders(recording.Headers, interaction.AssertHeaders)
+		}
+		if isModified(recorded, recording) {
+			require.Failf(t, "request variant changed the response", "interaction %d: variant %d (%s %s) does not match the recorded response:\n%s", i, j, request.Method, request.URL, diffResponses(recorded, recording, cfg))
+		}
+	}
+}
+
+// checkIdempotent serves interaction with handler again and fails if the response differs from first.
+func checkIdempotent(t *testing.T, i int, handler http.Handler, interaction *Interaction, first *Response, cfg *config) {
+	t.Helper()
+	request, err := newRequest(interaction, cfg)
+	require.NoError(t, err)
+	request = withInteraction(request, i, interaction)
+	request, cancel := withDeadline(request, cfg)
+	defer cancel()
+	recorder := httptest.NewRecorder()
+	informational := &informationalRecorder{ResponseWriter: recorder}
+	serveInteraction(t, i, handler, informational, request, cfg.timeout)
+	again := newRecording(request, recorder, cfg)
+	// only the first run records the order of its headers
+	again.HeaderOrder = first.HeaderOrder
+	if cfg.capture1xx {
+		again.Informational = informational.responses
+	}
+	if isResponseModified(first, again, cfg) {
+		require.Equalf(t, comparable(first, cfg, nil), comparable(again, cfg, nil), "interaction %d: %s %s returned a different response when repeated", i, request.Method, request.URL.Path)
+	}
+}
+
+// RequireRecordedWith fails a replay when the recorded_with of the cassette does not start with prefix, to catch
+// cassettes pasted in from other tools that happen to parse. With -overwrite a cassette with no recorded_with,
+// such as a Skeleton, is stamped with prefix instead.
+func RequireRecordedWith(prefix string) ReplayOption {
+	return func(c *config) {
+		c.recordedWith = prefix
+	}
+}
+
+// checkRecordedWith fails if tape was not recorded with the prefix set by RequireRecordedWith.
+func checkRecordedWith(t *testing.T, tape *Cassette, prefix string) {
+	t.Helper()
+	if tape.RecordedWith == "" && overwriting() {
+		tape.RecordedWith = prefix
+	}
+	require.Truef(t, strings.HasPrefix(tape.RecordedWith, prefix), "cassette was recorded with %q, expected %q", tape.RecordedWith, prefix)
+}
+
+// MaxBodySize fails a replay when the body of a response is larger than n bytes after normalization, to guard
+// endpoints whose payloads must stay small.
+func MaxBodySize(n int) ReplayOption {
+	return func(c *config) {
+		c.maxBodySize = n
+		c.limitBodySize = true
+	}
+}
+
+// checkBodySize fails if the normalized body of recording is larger than cfg allows.
+func checkBodySize(t *testing.T, i int, r *http.Request, recording *Response, cfg *config) {
+	t.Helper()
+	body, err := comparable(recording, cfg, nil).Body.decode()
+	if err != nil {
+		return
+	}
+	require.LessOrEqualf(t, len(body), cfg.maxBodySize, "interaction %d: %s %s returned a body of %d bytes, more than the limit of %d", i, r.Method, r.URL, len(body), cfg.maxBodySize)
+}
+
+// defaultSecretPatterns are the patterns ScanForSecrets looks for when given none. The last two are generic, and
+// kept conservative by only matching values of 16 or more characters that follow Bearer or a field name.
+var defaultSecretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`),
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+	regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{16,}`),
+	regexp.MustCompile(`(?i)(api[_-]?key|access[_-]?token|client[_-]?secret)\\?["']?\s*[:=]\s*(-\s+)?\\?["']?[A-Za-z0-9._~+/-]{16,}`),
+}
+
+// ScanForSecrets makes -overwrite refuse to write a cassette in which any interaction matches one of patterns, so
+// that credentials are not committed with a fixture. With no patterns it looks for AWS access key IDs, JWTs, PEM
+// private keys, GitHub, Slack and Stripe tokens, bearer tokens and the values of fields or headers named like API
+// keys, access tokens or client secrets; other credentials are only found by passing patterns for them. Strings
+// are not judged by their entropy, as the hashes and IDs in most cassettes would be reported too. Interactions
+// are scanned as they are encoded, before bodies are moved to files or compressed.
+func ScanForSecrets(patterns ...*regexp.Regexp) ReplayOption {
+	if len(patterns) == 0 {
+		patterns = defaultSecretPatterns
+	}
+	return func(c *config) {
+		c.secretPatterns = append(c.secretPatterns, patterns...)
+	}
+}
+
+// checkSecrets fails if an interaction of tape matches one of patterns. The matching text is not shown so that
+// it does not end up in the test output.
+func checkSecrets(t *testing.T, path string, tape *Cassette, patterns []*regexp.Regexp) {
+	t.Helper()
+	for i, interaction := range tape.Interactions {
+		encoded, err := yaml.Marshal(interaction)
+		require.NoError(t, err)
+		for _, pattern := range patterns {
+			if pattern.Match(encoded) {
+				require.FailNowf(t, "secret found", "refusing to overwrite %s: interaction %d matches the secret pattern %s", path, i, pattern)
+			}
+		}
+	}
+}
+
+// TerminalRedirects compares 3xx responses by their status and Location header only, treating the body and its
+// Content-Length as insignificant, and fails with a dedicated message when the handler redirects somewhere
+// other than the recorded Location. With -overwrite the new Location is recorded instead.
+func TerminalRedirects() ReplayOption {
+	return func(c *config) {
+		c.terminalRedirects = true
+	}
+}
+
+// isRedirect reports whether resp is a redirection.
+func isRedirect(resp *Response) bool {
+	return resp != nil && resp.Status.Code >= 300 && resp.Status.Code < 400
+}
+
+// checkRedirect fails if recording redirects to a different Location than the recorded response.
+func checkRedirect(t *testing.T, i int, r *http.Request, recorded *Response, recording *Response) {
+	t.Helper()
+	if !isRedirect(recorded) || !isRedirect(recording) {
+		return
+	}
+	expected, actual := recorded.Headers.Get("Location"), recording.Headers.Get("Location")
+	require.Equalf(t, expected, actual, "interaction %d: %s %s redirected to %q but the recording expects %q", i, r.Method, r.URL.Path, actual, expected)
+}
+
+// AssertNormalizersComparisonOnly fails a replay if comparing responses changes the recorded response or the
+// handler's response, which would mean a normalizer altered what is stored in the cassette rather than only the
+// copy that is compared.
+func AssertNormalizersComparisonOnly() ReplayOption {
+	return func(c *config) {
+		c.comparisonOnly = true
+	}
+}
+
+// checkComparisonOnly calls compare and fails if it changed recorded or recording.
+func checkComparisonOnly(t *testing.T, i int, r *http.Request, recorded *Response, recording *Response, compare func(before *Response, after *Response) bool) bool {
+	t.Helper()
+	before, err := yaml.Marshal([]*Response{recorded, recording})
+	require.NoError(t, err)
+	modified := compare(recorded, recording)
+	after, err := yaml.Marshal([]*Response{recorded, recording})
+	require.NoError(t, err)
+	require.Equalf(t, string(before), string(after), "interaction %d: %s %s: a normalizer changed the stored response instead of only the copy that is compared", i, r.Method, r.URL.Path)
+	return modified
+}
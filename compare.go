@@ -0,0 +1,139 @@
+package vcr
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// ReplayCompare replays the cassette at name against each of handlers in a subtest named after it and fails
+// the test with a diff of every response that differs from the recording, then logs a matrix of which
+// interactions each handler reproduced. Interactions of a handler whose subtest stopped early, for example on a
+// different status code, are marked as failed. It is meant for checking that versions of a handler stay
+// compatible, so the cassette is never written and -overwrite has no effect.
+func ReplayCompare(t *testing.T, name string, handlers map[string]http.Handler, opts ...Option) {
+	t.Helper()
+
+	cfg := newConfig(opts)
+
+	defer lockTape(name)()
+
+	names := make([]string, 0, len(handlers))
+	for version := range handlers {
+		names = append(names, version)
+	}
+	sort.Strings(names)
+
+	var interactions []string
+	results := make(map[string][]string, len(names))
+	for _, version := range names {
+		tape, err := Load(name)
+		require.NoError(t, err)
+		if interactions == nil {
+			for i, interaction := range tape.Interactions {
+				interactions = append(interactions, fmt.Sprintf("%d: %s %s", i, strings.ToUpper(interaction.Request.Method), interaction.Request.URI))
+			}
+		}
+
+		// replay replaces the response of each interaction that changed
+		recorded := make([]*Response, len(tape.Interactions))
+		for i, interaction := range tape.Interactions {
+			recorded[i] = interaction.Response
+		}
+
+		handler := withMiddleware(handlers[version], cfg)
+		var completed bool
+		t.Run(version, func(t *testing.T) {
+			replay(t, handler, tape, cfg)
+			completed = true
+		})
+
+		result := make([]string, len(tape.Interactions))
+		for i, interaction := range tape.Interactions {
+			switch {
+			case interaction.Response != recorded[i]:
+				result[i] = "changed"
+				t.Errorf("%s: interaction %s does not match the recording:\n%s", version, interactions[i], diffResponses(recorded[i], interaction.Response, cfg))
+			case !completed:
+				// the subtest stopped, perhaps at this interaction, so it cannot be said to be the same
+				result[i] = "failed"
+			default:
+				result[i] = "same"
+			}
+		}
+		results[version] = result
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "interaction\t%s\n", strings.Join(names, "\t"))
+	for i, interaction := range interactions {
+		row := make([]string, len(names))
+		for j, version := range names {
+			row[j] = results[version][i]
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", interaction, strings.Join(row, "\t"))
+	}
+	_ = w.Flush()
+	t.Logf("compared %s:\n%s", name, b.String())
+}
+
+// TestCase is one of the setups ReplayWithSetup replays a cassette against.
+type TestCase struct {
+	// Name identifies the case, it is used as the name of its subtest.
+	Name string
+	// Now is the time the clock given to the handler should report.
+	Now time.Time
+}
+
+// ReplayWithSetup replays the cassette at name against the handler setup builds for each of cases, in a subtest
+// named after the case, for handlers whose behaviour depends on something injected such as a clock. With
+// -overwrite the cassette is recorded with the first case and the others are compared against that recording.
+func ReplayWithSetup(t *testing.T, name string, setup func(tc TestCase) http.Handler, cases []TestCase, opts ...Option) {
+	t.Helper()
+
+	cfg := newConfig(opts)
+
+	for i, tc := range cases {
+		overwrite := overwriting() && i == 0
+		fn := diffTape
+		if overwrite {
+			fn = overwriteTape
+		}
+		handler := withMiddleware(setup(tc), cfg)
+		t.Run(tc.Name, func(t *testing.T) {
+			defer lockTape(name)()
+
+			if cfg.streaming {
+				streamTape(t, name, handler, cfg, overwrite)
+				return
+			}
+
+			fn(t, name, cfg, func(tape *Cassette) int {
+				return replay(t, handler, tape, cfg)
+			})
+		})
+	}
+}
+
+// diffResponses returns a unified diff of the comparable forms of recorded and actual.
+func diffResponses(recorded *Response, actual *Response, cfg *config) string {
+	before, _ := yaml.Marshal(comparable(recorded, cfg, nil))
+	after, _ := yaml.Marshal(comparable(actual, cfg, nil))
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before)),
+		B:        difflib.SplitLines(string(after)),
+		FromFile: "recorded",
+		ToFile:   "actual",
+		Context:  3,
+	})
+	return diff
+}
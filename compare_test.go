@@ -0,0 +1,76 @@
+package vcr_test
+
+import (
+	"bytes"
+	"flag"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayCompare(t *testing.T) {
+	v1 := http.NewServeMux()
+	v1.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Hello world!", 200)
+	})
+	v2 := http.NewServeMux()
+	v2.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		_, _ = w.Write([]byte("Hello world!\n"))
+	})
+	vcr.ReplayCompare(t, "vcr_test.yml", map[string]http.Handler{"v1": v1, "v2": v2})
+}
+
+func TestReplayWithSetup(t *testing.T) {
+	setup := func(tc vcr.TestCase) http.Handler {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+			greeting := "Hello world!"
+			if tc.Now.Hour() >= 18 {
+				greeting = "Good evening world!"
+			}
+			http.Error(w, greeting, 200)
+		})
+		return mux
+	}
+	vcr.ReplayWithSetup(t, "vcr_test.yml", setup, []vcr.TestCase{
+		{Name: "morning", Now: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)},
+		{Name: "afternoon", Now: time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC)},
+	})
+}
+
+func TestReplayWithSetupStreaming(t *testing.T) {
+	output := expectFailure(t, func(t *testing.T) {
+		tape, err := vcr.Load("vcr_test.yml")
+		require.NoError(t, err)
+		var stream bytes.Buffer
+		require.NoError(t, vcr.WriteStream(&stream, tape))
+		path := filepath.Join(t.TempDir(), "stream.yml")
+		require.NoError(t, os.WriteFile(path, stream.Bytes(), 0o644))
+
+		setup := func(tc vcr.TestCase) http.Handler {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Hello at "+tc.Now.Format(time.Kitchen), 200)
+			})
+			return mux
+		}
+
+		// only the first case is recorded, the second is compared against it and differs
+		require.NoError(t, flag.Set("overwrite", "true"))
+		defer func() {
+			require.NoError(t, flag.Set("overwrite", "false"))
+		}()
+		vcr.ReplayWithSetup(t, path, setup, []vcr.TestCase{
+			{Name: "morning", Now: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)},
+			{Name: "evening", Now: time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC)},
+		}, vcr.Streaming())
+	})
+	require.Contains(t, output, "--- FAIL: TestReplayWithSetupStreaming/evening")
+	require.NotContains(t, output, "--- FAIL: TestReplayWithSetupStreaming/morning")
+}
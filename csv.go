@@ -0,0 +1,50 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	RegisterNormalizer("text/csv", NormalizeCSV(false))
+}
+
+// NormalizeCSV rewrites a CSV body with minimal quoting, \n line endings and no trailing whitespace in its fields,
+// so that only the values are compared. With sortRows the rows after the header are sorted, for endpoints
+// that do not return them in a stable order. Bodies that are not valid CSV are left untouched. NormalizeCSV(false)
+// is registered for text/csv.
+func NormalizeCSV(sortRows bool) NormalizeOption {
+	return func(resp *Response) {
+		body, err := resp.Body.decode()
+		if err != nil {
+			return
+		}
+		reader := csv.NewReader(strings.NewReader(body))
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return
+		}
+		for _, record := range records {
+			for i, field := range record {
+				record[i] = strings.TrimRightFunc(field, unicode.IsSpace)
+			}
+		}
+		if sortRows && len(records) > 1 {
+			rows := records[1:]
+			sort.SliceStable(rows, func(i, j int) bool {
+				return strings.Join(rows[i], "\x00") < strings.Join(rows[j], "\x00")
+			})
+		}
+
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		if err := writer.WriteAll(records); err != nil {
+			return
+		}
+		resp.Body = newBody(buf.String())
+	}
+}
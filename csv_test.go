@@ -0,0 +1,23 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeCSV(t *testing.T) {
+	report := func(body string, opts ...vcr.NormalizeOption) *vcr.Response {
+		resp := &vcr.Response{Headers: http.Header{"Content-Type": {"text/csv; charset=utf-8"}}}
+		resp.Body.String = body
+		return vcr.Normalize(resp, opts...)
+	}
+
+	a := report("name,note\r\n\"Smith, J\",\"paid \"\r\nDoe,\"said \"\"hi\"\"\"\r\n")
+	require.Equal(t, "name,note\n\"Smith, J\",paid\nDoe,\"said \"\"hi\"\"\"\n", a.Body.String)
+
+	b := report("name,note\nDoe,\"said \"\"hi\"\"\"\n\"Smith, J\",paid\n", vcr.NormalizeCSV(true))
+	require.Equal(t, report(a.Body.String, vcr.NormalizeCSV(true)).Body.String, b.Body.String)
+	require.Equal(t, "name,note\nDoe,\"said \"\"hi\"\"\"\n\"Smith, J\",paid\n", b.Body.String)
+}
@@ -0,0 +1,76 @@
+package vcr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ExportCurl writes the requests of the cassette at path to w as a shell script with a curl command for each
+// interaction, so that they can be reproduced by hand or shared with someone who does not use Go. Each command
+// sends the recorded method, URI, headers, cookies and body, with every argument quoted for the shell.
+func ExportCurl(path string, w io.Writer) error {
+	tape, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	for i, interaction := range tape.Interactions {
+		command, err := curlCommand(&interaction.Request)
+		if err != nil {
+			return fmt.Errorf("interaction %d: %w", i, err)
+		}
+		_, _ = fmt.Fprintf(&b, "\n# interaction %d\n%s\n", i, command)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// curlCommand returns the curl command that sends r, with one option per line.
+func curlCommand(r *Request) (string, error) {
+	args := []string{"curl -X " + shellQuote(strings.ToUpper(r.Method)) + " " + shellQuote(r.URI)}
+
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Headers[name] {
+			args = append(args, "-H "+shellQuote(name+": "+value))
+		}
+	}
+
+	if cookies := recordedCookieHeader(r.Cookies); cookies != "" {
+		args = append(args, "-b "+shellQuote(cookies))
+	}
+
+	if body, ok, err := r.body(); err != nil {
+		return "", err
+	} else if ok {
+		args = append(args, "--data-binary "+shellQuote(body))
+	}
+	return strings.Join(args, " \\\n  "), nil
+}
+
+// recordedCookieHeader formats cookies as the value of a Cookie header, in order of name.
+func recordedCookieHeader(cookies map[string]string) string {
+	names := make([]string, 0, len(cookies))
+	for name := range cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + cookies[name]
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// shellQuote quotes s as a single argument for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,40 @@
+package vcr_test
+
+import (
+	"bytes"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportCurl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "curl.yml")
+	require.NoError(t, vcr.Save(path, &vcr.Cassette{Interactions: []*vcr.Interaction{{
+		Request: vcr.Request{Method: "get", URI: "http://localhost/hello-world", Headers: http.Header{}},
+	}, {
+		Request: vcr.Request{
+			Method:  "post",
+			URI:     "http://localhost/notes",
+			Headers: http.Header{"Content-Type": {"application/json"}, "Accept": {"application/json"}},
+			Body:    &vcr.Body{String: `{"text":"it's done"}`},
+			Cookies: map[string]string{"session": "f00dcafe", "theme": "dark"},
+		},
+	}}}))
+
+	var buf bytes.Buffer
+	require.NoError(t, vcr.ExportCurl(path, &buf))
+	require.Equal(t, `#!/bin/sh
+
+# interaction 0
+curl -X 'GET' 'http://localhost/hello-world'
+
+# interaction 1
+curl -X 'POST' 'http://localhost/notes' \
+  -H 'Accept: application/json' \
+  -H 'Content-Type: application/json' \
+  -b 'session=f00dcafe; theme=dark' \
+  --data-binary '{"text":"it'\''s done"}'
+`, buf.String())
+}
@@ -0,0 +1,96 @@
+package vcr
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DiffCassettes compares the cassettes at a and b and returns a unified diff of their interactions, or an empty
+// string if they are the same. Comments, formatting, recorded_at timestamps, recorded_by tests and logs are ignored
+// and responses are compared after applying opts, in the same way as Replay.
+func DiffCassettes(a, b string, opts ...NormalizeOption) (string, error) {
+	cfg := newConfig(nil)
+	cfg.normalizers = opts
+
+	before, err := diffable(a, cfg)
+	if err != nil {
+		return "", err
+	}
+	after, err := diffable(b, cfg)
+	if err != nil {
+		return "", err
+	}
+	if before == after {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: a,
+		ToFile:   b,
+		Context:  3,
+	})
+}
+
+// describeChanges lists the interactions of tape whose response is no longer the one in recorded, each as its index,
+// path and a short description of what changed, such as "[1: /users status 200→500]".
+func describeChanges(tape *Cassette, recorded []*Response, cfg *config) []string {
+	var changes []string
+	for i, interaction := range tape.Interactions {
+		if i < len(recorded) && interaction.Response == recorded[i] {
+			continue
+		}
+		path := interaction.Request.URI
+		if uri, err := url.Parse(path); err == nil && uri.Path != "" {
+			path = uri.Path
+		}
+		var before *Response
+		if i < len(recorded) {
+			before = recorded[i]
+		}
+		changes = append(changes, fmt.Sprintf("[%d: %s %s]", i, path, describeChange(before, interaction.Response, cfg)))
+	}
+	return changes
+}
+
+// describeChange summarises how after differs from before once both are normalized.
+func describeChange(before, after *Response, cfg *config) string {
+	before, after = comparable(before, cfg, nil), comparable(after, cfg, nil)
+	switch {
+	case before == nil:
+		return "was not recorded"
+	case after == nil:
+		return "has no response"
+	case before.Status.Code != after.Status.Code:
+		return fmt.Sprintf("status %d→%d", before.Status.Code, after.Status.Code)
+	case !reflect.DeepEqual(before.Body, after.Body):
+		return "body differs"
+	case !reflect.DeepEqual(before.Headers, after.Headers):
+		return "headers differ"
+	default:
+		return "response differs"
+	}
+}
+
+// diffable loads the cassette at path and encodes it in the form DiffCassettes compares.
+func diffable(path string, cfg *config) (string, error) {
+	tape, err := Load(path)
+	if err != nil {
+		return "", err
+	}
+	for _, interaction := range tape.Interactions {
+		interaction.Response = comparable(interaction.Response, cfg, nil)
+		interaction.RecordedAt = ""
+		interaction.RecordedBy = ""
+		interaction.Log = ""
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, tape); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,87 @@
+package vcr_test
+
+import (
+	"bytes"
+	"flag"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestDiffCassettes(t *testing.T) {
+	contents, err := os.ReadFile("vcr_test.yml")
+	require.NoError(t, err)
+	contents = regexp.MustCompile(`recorded_at: .*`).ReplaceAll(contents, []byte(`recorded_at: Mon, 01 Jan 2024 00:00:00 GMT`))
+	path := filepath.Join(t.TempDir(), "retimed.yml")
+	require.NoError(t, os.WriteFile(path, append([]byte("# a comment\n"), contents...), 0o644))
+
+	diff, err := vcr.DiffCassettes("vcr_test.yml", path)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+
+	require.NoError(t, os.WriteFile(path, bytes.Replace(contents, []byte("Hello world!"), []byte("Hello there!"), 1), 0o644))
+	diff, err = vcr.DiffCassettes("vcr_test.yml", path)
+	require.NoError(t, err)
+	require.Contains(t, diff, "-          Hello world!\n+          Hello there!\n")
+
+	diff, err = vcr.DiffCassettes("vcr_test.yml", path, vcr.ReplaceString("there", "world"))
+	require.NoError(t, err)
+	require.Empty(t, diff)
+}
+
+func TestDescribeChanges(t *testing.T) {
+	output := expectFailure(t, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "changes.yml")
+		require.NoError(t, os.WriteFile(path, vcr.Skeleton([]vcr.RecordedRequest{
+			{Method: "GET", URI: "http://localhost/a"},
+			{Method: "GET", URI: "http://localhost/b?page=2"},
+		}), 0o644))
+
+		recorded := map[string]string{"/a": "a", "/b": "b"}
+		require.NoError(t, flag.Set("overwrite", "true"))
+		defer func() {
+			require.NoError(t, flag.Set("overwrite", "false"))
+		}()
+		vcr.Replay(t, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, recorded[r.URL.Path])
+		}))
+
+		require.NoError(t, flag.Set("overwrite", "false"))
+		recorded["/b"] = "changed"
+		vcr.Replay(t, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, recorded[r.URL.Path])
+		}))
+	})
+	require.Contains(t, output, "1 of 2 interactions changed: [1: /b body differs]. run this test with the -overwrite flag")
+}
+
+func TestDescribeChangesStatus(t *testing.T) {
+	output := expectFailure(t, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "status.yml")
+		require.NoError(t, vcr.Save(path, &vcr.Cassette{}))
+		request := func(baseURL string) {
+			resp, err := http.Get(baseURL + "/users")
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		require.NoError(t, flag.Set("overwrite", "true"))
+		defer func() {
+			require.NoError(t, flag.Set("overwrite", "false"))
+		}()
+		vcr.ReplayServer(t, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "[]")
+		}), request)
+
+		require.NoError(t, flag.Set("overwrite", "false"))
+		vcr.ReplayServer(t, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "[]", http.StatusInternalServerError)
+		}), request)
+	})
+	require.Contains(t, output, "1 of 1 interactions changed: [0: /users status 200→500]. run this test with the -overwrite flag")
+}
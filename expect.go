@@ -0,0 +1,45 @@
+package vcr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Expect serves a single request with handler and checks that the response matches want after
+// normalization, without reading or writing a cassette. An empty body encoding in want is taken to be
+// UTF-8 and a missing Content-Length header is filled in from the body.
+func Expect(t *testing.T, req RecordedRequest, want Response, handler http.Handler, opts ...Option) {
+	t.Helper()
+
+	cfg := newConfig(opts)
+	handler = withMiddleware(handler, cfg)
+
+	interaction := &Interaction{Request: req.toRequest()}
+	request, err := newRequest(interaction, cfg)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	serveInteraction(t, 0, handler, recorder, request, cfg.timeout)
+	recording := newRecording(request, recorder, cfg)
+
+	want.Headers = want.Headers.Clone()
+	if want.Headers == nil {
+		want.Headers = http.Header{}
+	}
+	if want.Body.Encoding == "" {
+		want.Body.Encoding = encodingUTF8
+	}
+	if want.Headers.Get("Content-Length") == "" {
+		if body, err := want.Body.decode(); err == nil {
+			want.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+
+	if isResponseModified(&want, recording, cfg) {
+		require.Equal(t, comparable(&want, cfg, nil), comparable(recording, cfg, nil), "response for %s %s does not match", request.Method, request.URL)
+	}
+}
@@ -0,0 +1,23 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"net/http"
+	"testing"
+)
+
+func TestExpect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Hello world!", 200)
+	})
+
+	want := vcr.Response{Headers: http.Header{
+		"Content-Type":           {"text/plain; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+	}}
+	want.Status.Code = 200
+	want.Body.String = "Hello world!\n"
+
+	vcr.Expect(t, vcr.RecordedRequest{Method: "GET", URI: "http://localhost/hello-world"}, want, mux)
+}
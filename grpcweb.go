@@ -0,0 +1,61 @@
+package vcr
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterNormalizer("application/grpc-web", NormalizeGRPCWeb())
+	RegisterNormalizer("application/grpc-web+proto", NormalizeGRPCWeb())
+}
+
+// grpcWebTrailerFlag marks a frame as containing trailers rather than a message.
+const grpcWebTrailerFlag = 0x80
+
+// NormalizeGRPCWeb splits a length-prefixed grpc-web body into its frames and rewrites it as one line per
+// frame, so that responses are compared frame-by-frame. Messages are base64 encoded and trailers are kept
+// as text. Bodies that are not well-formed are left untouched.
+func NormalizeGRPCWeb() NormalizeOption {
+	return func(resp *Response) {
+		body, err := resp.Body.decode()
+		if err != nil {
+			return
+		}
+		frames, ok := splitGRPCWebFrames(body)
+		if !ok {
+			return
+		}
+		resp.Body = Body{Encoding: encodingUTF8, String: strings.Join(frames, "\n")}
+	}
+}
+
+// splitGRPCWebFrames renders each frame in body as a single line.
+func splitGRPCWebFrames(body string) ([]string, bool) {
+	var frames []string
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, false
+		}
+		flag := body[0]
+		length := binary.BigEndian.Uint32([]byte(body[1:5]))
+		if uint64(len(body)-5) < uint64(length) {
+			return nil, false
+		}
+		message := body[5 : 5+length]
+		body = body[5+length:]
+
+		if flag&grpcWebTrailerFlag != 0 {
+			trailers := strings.Split(strings.TrimSpace(message), "\r\n")
+			for i := range trailers {
+				trailers[i] = strings.TrimSpace(trailers[i])
+			}
+			frames = append(frames, fmt.Sprintf("trailers: %s", strings.Join(trailers, "; ")))
+		} else {
+			frames = append(frames, fmt.Sprintf("message(%#x): %s", flag, base64.StdEncoding.EncodeToString([]byte(message))))
+		}
+	}
+	return frames, true
+}
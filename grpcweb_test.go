@@ -0,0 +1,30 @@
+package vcr_test
+
+import (
+	"encoding/base64"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func grpcWebFrame(flag byte, message string) string {
+	n := len(message)
+	return string([]byte{flag, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + message
+}
+
+func TestNormalizeGRPCWeb(t *testing.T) {
+	body := grpcWebFrame(0, "\x08\x96\x01") + grpcWebFrame(0x80, "grpc-status: 0\r\ngrpc-message: OK\r\n")
+
+	resp := &vcr.Response{}
+	resp.Body.Encoding = "BASE64"
+	resp.Body.String = base64.StdEncoding.EncodeToString([]byte(body))
+	vcr.NormalizeGRPCWeb()(resp)
+
+	require.Equal(t, "UTF-8", resp.Body.Encoding)
+	require.Equal(t, "message(0x0): CJYB\ntrailers: grpc-status: 0; grpc-message: OK", resp.Body.String)
+
+	truncated := &vcr.Response{}
+	truncated.Body.String = body[:4]
+	vcr.NormalizeGRPCWeb()(truncated)
+	require.Equal(t, body[:4], truncated.Body.String)
+}
@@ -0,0 +1,324 @@
+package vcr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// harVersion is the version of the HTTP Archive format written by ExportHAR.
+const harVersion = "1.2"
+
+// har is an HTTP Archive, as described at http://www.softwareishard.com/blog/har-12-spec/.
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// ExportHAR writes the interactions of the cassette at path to w as an HTTP Archive, so that they can be opened
+// in browser developer tools and other HAR viewers. Interactions without a response are left out, bodies that
+// are not valid UTF-8 are base64 encoded and bodies stored as a hash are exported without their contents.
+// The delay_ms of an interaction is exported as the time spent waiting for the response.
+func ExportHAR(path string, w io.Writer) error {
+	tape, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	archive := har{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "go-vcr"},
+		Entries: []harEntry{},
+	}}
+	for _, interaction := range tape.Interactions {
+		if interaction.Response == nil {
+			continue
+		}
+		entry, err := newHAREntry(interaction)
+		if err != nil {
+			return err
+		}
+		archive.Log.Entries = append(archive.Log.Entries, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// newHAREntry converts interaction into a HAR entry.
+func newHAREntry(interaction *Interaction) (harEntry, error) {
+	request, response := interaction.Request, interaction.Response
+
+	var startedAt time.Time
+	if interaction.RecordedAt != "" {
+		recordedAt, err := parseRecordedAt(interaction.RecordedAt)
+		if err != nil {
+			return harEntry{}, err
+		}
+		startedAt = recordedAt
+	}
+
+	requestURI, err := url.Parse(request.URI)
+	if err != nil {
+		return harEntry{}, err
+	}
+
+	httpVersion := "HTTP/1.1"
+	if response.HttpVersion != nil {
+		httpVersion = "HTTP/" + *response.HttpVersion
+	}
+
+	entry := harEntry{
+		StartedDateTime: startedAt.UTC().Format(time.RFC3339Nano),
+		Time:            float64(interaction.DelayMs),
+		Request: harRequest{
+			Method:      strings.ToUpper(request.Method),
+			URL:         request.URI,
+			HTTPVersion: httpVersion,
+			Cookies:     harCookies(request.Cookies),
+			Headers:     harHeaders(request.Headers),
+			QueryString: harValues(requestURI.Query()),
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Status:      response.Status.Code,
+			StatusText:  http.StatusText(response.Status.Code),
+			HTTPVersion: httpVersion,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(response.Headers),
+			RedirectURL: response.Headers.Get("Location"),
+			HeadersSize: -1,
+		},
+		Timings: harTimings{Wait: float64(interaction.DelayMs)},
+	}
+	if response.Status.Message != nil {
+		entry.Response.StatusText = *response.Status.Message
+	}
+
+	if body, ok, err := request.body(); err != nil {
+		return harEntry{}, err
+	} else if ok {
+		entry.Request.PostData = &harPostData{MimeType: request.Headers.Get("Content-Type"), Text: body}
+		entry.Request.BodySize = len(body)
+	}
+
+	entry.Response.Content.MimeType = response.Headers.Get("Content-Type")
+	entry.Response.BodySize = -1
+	if body, err := response.Body.decode(); err == nil {
+		entry.Response.Content.Size = len(body)
+		entry.Response.BodySize = len(body)
+		entry.Response.Content.Text = body
+		if !utf8.ValidString(body) {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString([]byte(body))
+			entry.Response.Content.Encoding = "base64"
+		}
+	}
+	return entry, nil
+}
+
+// harHeaders lists h in name order.
+func harHeaders(h http.Header) []harNameValue {
+	return harValues(url.Values(h))
+}
+
+// harValues lists values in name order.
+func harValues(values url.Values) []harNameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []harNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+// harCookies lists cookies in name order.
+func harCookies(cookies map[string]string) []harNameValue {
+	values := url.Values{}
+	for name, value := range cookies {
+		values.Set(name, value)
+	}
+	return harValues(values)
+}
+
+// ImportHAR reads an HTTP Archive, such as one saved from browser developer tools, and returns its entries as the
+// interactions of a cassette that can be written with Save. Entries are kept only if every keep function
+// accepts them, IsAPIRequest for example drops pages and static assets. Base64 encoded content is decoded,
+// HTTP/2 pseudo-headers are dropped and recorded_at is taken from when the request was started.
+func ImportHAR(r io.Reader, keep ...func(*Interaction) bool) (*Cassette, error) {
+	var archive har
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, err
+	}
+
+	tape := &Cassette{}
+entries:
+	for i, entry := range archive.Log.Entries {
+		interaction, err := entry.toInteraction()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		for _, fn := range keep {
+			if !fn(interaction) {
+				continue entries
+			}
+		}
+		tape.Interactions = append(tape.Interactions, interaction)
+	}
+	return tape, nil
+}
+
+// toInteraction converts entry into an interaction.
+func (entry harEntry) toInteraction() (*Interaction, error) {
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method:  entry.Request.Method,
+			URI:     entry.Request.URL,
+			Headers: fromHARHeaders(entry.Request.Headers),
+		}.toRequest(),
+		Response: &Response{Headers: fromHARHeaders(entry.Response.Headers)},
+		DelayMs:  int(entry.Timings.Wait),
+	}
+	if entry.Request.PostData != nil && entry.Request.PostData.Text != "" {
+		body := newBody(entry.Request.PostData.Text)
+		interaction.Request.Body = &body
+	}
+
+	body := entry.Response.Content.Text
+	if entry.Response.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(decoded)
+	}
+	interaction.Response.Body = newBody(body)
+	interaction.Response.Status.Code = entry.Response.Status
+	if message := entry.Response.StatusText; message != "" {
+		interaction.Response.Status.Message = &message
+	}
+	interaction.Response.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	if version := strings.TrimPrefix(strings.ToUpper(entry.Response.HTTPVersion), "HTTP/"); version != "" {
+		interaction.Response.HttpVersion = &version
+	}
+
+	recordedAt := time.Now()
+	if startedAt, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime); err == nil {
+		recordedAt = startedAt
+	}
+	interaction.RecordedAt = recordedAt.UTC().Format(http.TimeFormat)
+	return interaction, nil
+}
+
+// fromHARHeaders converts a list of HAR headers, leaving out HTTP/2 pseudo-headers such as :authority.
+func fromHARHeaders(list []harNameValue) http.Header {
+	h := http.Header{}
+	for _, header := range list {
+		if !strings.HasPrefix(header.Name, ":") {
+			h.Add(header.Name, header.Value)
+		}
+	}
+	return h
+}
+
+// IsAPIRequest reports whether interaction looks like a call to an API rather than the loading of a page or a
+// static asset such as a script, stylesheet, image or font, judging by the Content-Type of its response.
+func IsAPIRequest(interaction *Interaction) bool {
+	if interaction.Response == nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(interaction.Response.Headers.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	switch {
+	case mediaType == "text/html", mediaType == "text/css", strings.HasSuffix(mediaType, "javascript"),
+		strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "font/"),
+		strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	return true
+}
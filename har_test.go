@@ -0,0 +1,76 @@
+package vcr_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestExportHAR(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, vcr.ExportHAR("vcr_test.yml", &buf))
+
+	var archive struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				StartedDateTime string
+				Request         struct {
+					Method string
+					URL    string
+				}
+				Response struct {
+					Status  int
+					Content struct {
+						MimeType string
+						Text     string
+					}
+				}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &archive))
+	require.Equal(t, "1.2", archive.Log.Version)
+	require.Len(t, archive.Log.Entries, 1)
+
+	entry := archive.Log.Entries[0]
+	require.Equal(t, "GET", entry.Request.Method)
+	require.Equal(t, "http://localhost/hello-world", entry.Request.URL)
+	require.Equal(t, 200, entry.Response.Status)
+	require.Equal(t, "text/plain; charset=utf-8", entry.Response.Content.MimeType)
+	require.Equal(t, "Hello world!\n", entry.Response.Content.Text)
+}
+
+func TestImportHAR(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, vcr.ExportHAR("vcr_test.yml", &buf))
+
+	tape, err := vcr.ImportHAR(&buf)
+	require.NoError(t, err)
+	require.Len(t, tape.Interactions, 1)
+
+	expected, err := vcr.Load("vcr_test.yml")
+	require.NoError(t, err)
+	interaction := tape.Interactions[0]
+	require.Equal(t, expected.Interactions[0].Request.URI, interaction.Request.URI)
+	require.Equal(t, expected.Interactions[0].Response.Body, interaction.Response.Body)
+	require.Equal(t, expected.Interactions[0].Response.Headers, interaction.Response.Headers)
+	require.Equal(t, expected.Interactions[0].RecordedAt, interaction.RecordedAt)
+}
+
+func TestImportHARIsAPIRequest(t *testing.T) {
+	archive := `{"log": {"entries": [
+		{"request": {"method": "GET", "url": "https://example.com/"}, "response": {"status": 200, "headers": [{"name": "Content-Type", "value": "text/html"}], "content": {"text": "<html>"}}},
+		{"request": {"method": "GET", "url": "https://example.com/api/users", "headers": [{"name": ":authority", "value": "example.com"}]}, "response": {"status": 200, "headers": [{"name": "Content-Type", "value": "application/json"}], "content": {"text": "W10=", "encoding": "base64"}}}
+	]}}`
+
+	tape, err := vcr.ImportHAR(strings.NewReader(archive), vcr.IsAPIRequest)
+	require.NoError(t, err)
+	require.Len(t, tape.Interactions, 1)
+	require.Equal(t, "https://example.com/api/users", tape.Interactions[0].Request.URI)
+	require.Empty(t, tape.Interactions[0].Request.Headers)
+	require.Equal(t, "[]", tape.Interactions[0].Response.Body.String)
+}
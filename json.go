@@ -0,0 +1,188 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// isJSON reports whether the Content-Type in h is a JSON media type.
+func isJSON(h http.Header) bool {
+	if h == nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// looksLikeJSON reports whether input is a JSON object or array, whatever its declared content type.
+func looksLikeJSON(input string) bool {
+	trimmed := strings.TrimSpace(input)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return false
+	}
+	_, ok := decodeJSON(trimmed)
+	return ok
+}
+
+// decodeJSON decodes input, keeping numbers as json.Number so that re-encoding does not lose precision.
+func decodeJSON(input string) (any, bool) {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, false
+	}
+	// reject trailing data, it is not a single JSON document
+	if decoder.More() {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// duplicateJSONKey returns the path of the first key that appears twice in the same object of input. It
+// reports false if there is none or input is not valid JSON.
+func duplicateJSONKey(input string) (string, bool) {
+	path, found, err := scanJSONKeys(json.NewDecoder(strings.NewReader(input)), "$")
+	return path, found && err == nil
+}
+
+// scanJSONKeys reads the next value from decoder token by token, so that keys are seen before decoding
+// collapses them, and returns the path of the first duplicate key within it.
+func scanJSONKeys(decoder *json.Decoder, path string) (string, bool, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", false, err
+	}
+	switch token {
+	case json.Delim('{'):
+		seen := map[string]bool{}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return "", false, err
+			}
+			key, _ := token.(string)
+			if seen[key] {
+				return path + "." + key, true, nil
+			}
+			seen[key] = true
+			if duplicate, found, err := scanJSONKeys(decoder, path+"."+key); found || err != nil {
+				return duplicate, found, err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; decoder.More(); i++ {
+			if duplicate, found, err := scanJSONKeys(decoder, fmt.Sprintf("%s[%d]", path, i)); found || err != nil {
+				return duplicate, found, err
+			}
+		}
+	default:
+		return "", false, nil
+	}
+	// consume the closing delimiter
+	_, err = decoder.Token()
+	return "", false, err
+}
+
+// encodeJSON re-encodes a decoded document in the same form as normalizeJson.
+func encodeJSON(decoded any) (string, bool) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(decoded); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+// parseJSONPath splits a simple JSONPath expression such as $.items[*].tags into its segments.
+// Only child (.name) and wildcard ([*] or .*) segments are supported.
+func parseJSONPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.ReplaceAll(path, "[*]", ".*")
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// walkJSONPath calls fn with every value in doc addressed by segments. fn returns the replacement value.
+func walkJSONPath(doc any, segments []string, fn func(any) any) any {
+	if len(segments) == 0 {
+		return fn(doc)
+	}
+	segment, rest := segments[0], segments[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		if segment == "*" {
+			for key, value := range node {
+				node[key] = walkJSONPath(value, rest, fn)
+			}
+		} else if value, ok := node[segment]; ok {
+			node[segment] = walkJSONPath(value, rest, fn)
+		}
+	case []any:
+		if segment == "*" {
+			for i, value := range node {
+				node[i] = walkJSONPath(value, rest, fn)
+			}
+		}
+	}
+	return doc
+}
+
+// walkJSON calls fn with every value in doc, children first. fn returns the replacement value.
+func walkJSON(doc any, fn func(any) any) any {
+	switch node := doc.(type) {
+	case map[string]any:
+		for key, value := range node {
+			node[key] = walkJSON(value, fn)
+		}
+	case []any:
+		for i, value := range node {
+			node[i] = walkJSON(value, fn)
+		}
+	}
+	return fn(doc)
+}
+
+// sortJSONArray orders the elements of an array by their canonical serialization.
+func sortJSONArray(value any) any {
+	array, ok := value.([]any)
+	if !ok {
+		return value
+	}
+	keys := make([]string, len(array))
+	for i, element := range array {
+		// map keys are sorted by encoding/json so this is stable for objects
+		encoded, _ := json.Marshal(element)
+		keys[i] = string(encoded)
+	}
+	sort.Sort(byKey{keys: keys, values: array})
+	return array
+}
+
+type byKey struct {
+	keys   []string
+	values []any
+}
+
+func (s byKey) Len() int           { return len(s.keys) }
+func (s byKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s byKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
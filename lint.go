@@ -0,0 +1,93 @@
+package vcr
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lint checks the cassette at path without replaying it, returning every problem found: fields that are not
+// part of the format, recorded_at timestamps that do not parse, request URIs that are not absolute, status codes
+// outside 100-599, bodies that do not match their encoding and request bodies that disagree with their form. It
+// returns nil for a valid cassette.
+func Lint(path string) []error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return []error{err}
+	}
+	defer fd.Close()
+
+	tape, err := open(fd)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for i, interaction := range tape.Interactions {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("interaction %d: "+format, append([]any{i}, args...)...))
+		}
+
+		if interaction.RecordedAt != "" {
+			if _, err := parseRecordedAt(interaction.RecordedAt); err != nil {
+				fail("recorded_at: %w", err)
+			}
+		}
+
+		if uri, err := url.Parse(interaction.Request.URI); err != nil {
+			fail("uri: %w", err)
+		} else if !uri.IsAbs() {
+			fail("uri %q is not absolute", interaction.Request.URI)
+		}
+
+		if interaction.Request.Body != nil {
+			if err := lintBody(*interaction.Request.Body); err != nil {
+				fail("request body: %w", err)
+			} else if _, _, err := interaction.Request.body(); err != nil {
+				fail("request body: %w", err)
+			}
+		}
+
+		if interaction.Response == nil {
+			continue
+		}
+		if code := interaction.Response.Status.Code; code < 100 || code > 599 {
+			fail("status code %d is not between 100 and 599", code)
+		}
+		body := interaction.Response.Body
+		if body.File != "" {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), filepath.FromSlash(body.File))); err != nil {
+				fail("response body: %w", err)
+			}
+			continue
+		}
+		if err := lintBody(body); err != nil {
+			fail("response body: %w", err)
+		}
+	}
+	return errs
+}
+
+// lintBody checks that b can be decoded with its encoding.
+func lintBody(b Body) error {
+	switch {
+	case b.Encoding == "" || strings.EqualFold(b.Encoding, encodingUTF8):
+		if !utf8.ValidString(b.String) {
+			return fmt.Errorf("body is not valid %s", encodingUTF8)
+		}
+		return nil
+	case strings.EqualFold(b.Encoding, encodingSHA256):
+		if len(b.String) != 64 || strings.Trim(strings.ToLower(b.String), "0123456789abcdef") != "" {
+			return fmt.Errorf("body is not a %s hash", encodingSHA256)
+		}
+		return nil
+	case strings.EqualFold(b.Encoding, encodingBase64), strings.EqualFold(b.Encoding, encodingBase64URL), strings.EqualFold(b.Encoding, encodingGzip):
+		_, err := b.decode()
+		return err
+	default:
+		return fmt.Errorf("unknown encoding %q", b.Encoding)
+	}
+}
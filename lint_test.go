@@ -0,0 +1,73 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	require.Empty(t, vcr.Lint("vcr_test.yml"))
+	require.Empty(t, vcr.Lint("vcr_external_test.yml"))
+
+	path := filepath.Join(t.TempDir(), "lint.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`http_interactions:
+  - request:
+      method: get
+      uri: /relative
+      headers: {}
+    response:
+      status:
+        code: 1000
+      headers: {}
+      body:
+        encoding: BASE64
+        string: "!"
+    recorded_at: yesterday
+`), 0o644))
+
+	errs := vcr.Lint(path)
+	require.Len(t, errs, 4)
+	require.ErrorContains(t, errs[0], "interaction 0: recorded_at")
+	require.ErrorContains(t, errs[1], `uri "/relative" is not absolute`)
+	require.ErrorContains(t, errs[2], "status code 1000")
+	require.ErrorContains(t, errs[3], "response body")
+
+	require.NoError(t, os.WriteFile(path, []byte(`http_interactions:
+  - request:
+      method: get
+      uri: http://localhost/binary
+      headers: {}
+    response:
+      status:
+        code: 200
+      headers: {}
+      body:
+        encoding: BASE64URL
+        string: __v-
+    recorded_at: ""
+`), 0o644))
+	require.Empty(t, vcr.Lint(path))
+
+	require.NoError(t, os.WriteFile(path, []byte(`http_interactions:
+  - request:
+      method: post
+      uri: http://localhost/form
+      headers: {}
+      body:
+        encoding: UTF-8
+        string: a=1
+      form:
+        a: ["2"]
+    response: null
+    recorded_at: ""
+`), 0o644))
+	errs = vcr.Lint(path)
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "does not match form")
+
+	require.NoError(t, os.WriteFile(path, []byte("http_interactions: []\nunknown: true\n"), 0o644))
+	require.Len(t, vcr.Lint(path), 1)
+}
@@ -0,0 +1,103 @@
+package vcr
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// multipartBoundary replaces the boundaries of multipart bodies, which are usually random.
+const multipartBoundary = "vcr-boundary"
+
+// NormalizeMultipart rewrites multipart response bodies with a fixed boundary, so that they compare by the
+// headers and contents of their parts rather than by the boundary the handler happened to choose, both when
+// comparing and in the responses recorded by -overwrite and Recorder. The Content-Type and Content-Length headers
+// are updated to match. Use MatchMultipart to match requests the same way.
+func NormalizeMultipart() ReplayOption {
+	return func(c *config) {
+		c.normalizers = append(c.normalizers, normalizeMultipart)
+		c.canonicalMultipart = true
+	}
+}
+
+// normalizeMultipart rewrites a multipart body with multipartBoundary.
+func normalizeMultipart(resp *Response) {
+	body, err := resp.Body.decode()
+	if err != nil {
+		return
+	}
+	contentType, body, ok := canonicalMultipart(resp.Headers.Get("Content-Type"), body)
+	if !ok {
+		return
+	}
+	resp.Headers.Set("Content-Type", contentType)
+	if resp.Headers.Get("Content-Length") != "" {
+		resp.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	resp.Body = newBody(body)
+}
+
+// MatchMultipart matches requests with multipart bodies whose parts have the same headers and contents as the
+// recorded body, whatever their boundaries.
+func MatchMultipart(r *http.Request, recorded *Request) bool {
+	if r.Body == nil || recorded.Body == nil {
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	want, err := recorded.Body.decode()
+	if err != nil {
+		return false
+	}
+	_, got, ok := canonicalMultipart(r.Header.Get("Content-Type"), string(body))
+	if !ok {
+		return false
+	}
+	_, want, ok = canonicalMultipart(recorded.Headers.Get("Content-Type"), want)
+	return ok && got == want
+}
+
+// canonicalMultipart re-encodes a multipart body with multipartBoundary, returning the new Content-Type and
+// body, or false if contentType is not multipart or the body cannot be parsed.
+func canonicalMultipart(contentType string, body string) (string, string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return "", "", false
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(multipartBoundary); err != nil {
+		return "", "", false
+	}
+
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", false
+		}
+		w, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return "", "", false
+		}
+		if _, err := io.Copy(w, part); err != nil {
+			return "", "", false
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", "", false
+	}
+
+	params["boundary"] = multipartBoundary
+	return mime.FormatMediaType(mediaType, params), buf.String(), true
+}
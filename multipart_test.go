@@ -0,0 +1,81 @@
+package vcr_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func multipartBody(t *testing.T, contents string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("upload", "hello.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return writer.FormDataContentType(), buf.String()
+}
+
+func TestNormalizeMultipart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.yml")
+	require.NoError(t, os.WriteFile(path, vcr.Skeleton([]vcr.RecordedRequest{
+		{Method: "GET", URI: "http://localhost/download"},
+	}), 0o644))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		// a binary part makes the body invalid UTF-8, so it is stored as base64
+		contentType, body := multipartBody(t, "\xff\x00\xfe")
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte(body))
+	})
+
+	require.NoError(t, flag.Set("overwrite", "true"))
+	defer func() {
+		require.NoError(t, flag.Set("overwrite", "false"))
+	}()
+	vcr.Replay(t, path, mux, vcr.NormalizeMultipart())
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	response := tape.Interactions[0].Response
+	require.Equal(t, "multipart/form-data; boundary=vcr-boundary", response.Headers.Get("Content-Type"))
+	require.Equal(t, "BASE64", response.Body.Encoding)
+	contents, err := base64.StdEncoding.DecodeString(response.Body.String)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "--vcr-boundary\r\n")
+	require.Contains(t, string(contents), "\xff\x00\xfe")
+	require.Equal(t, strconv.Itoa(len(contents)), response.Headers.Get("Content-Length"))
+
+	// the handler picks a new boundary every time it is replayed
+	require.NoError(t, flag.Set("overwrite", "false"))
+	vcr.Replay(t, path, mux, vcr.NormalizeMultipart())
+}
+
+func TestMatchMultipart(t *testing.T) {
+	contentType, body := multipartBody(t, "hello")
+	tape := replayerTape("http://localhost/upload")
+	tape.Interactions[0].Request.Method = "post"
+	tape.Interactions[0].Request.Headers = http.Header{"Content-Type": {contentType}}
+	tape.Interactions[0].Request.Body = &vcr.Body{String: body}
+	client := &http.Client{Transport: vcr.NewReplayer(tape, vcr.MatchOn(vcr.MatchMethod, vcr.MatchURI, vcr.MatchMultipart))}
+
+	contentType, body = multipartBody(t, "goodbye")
+	_, err := client.Post("http://localhost/upload", contentType, bytes.NewBufferString(body))
+	require.ErrorContains(t, err, "no recorded interaction matches")
+
+	contentType, body = multipartBody(t, "hello")
+	resp, err := client.Post("http://localhost/upload", contentType, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
@@ -1,8 +1,19 @@
 package vcr
 
 import (
+	"mime"
 	"net/http"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func ReplacePattern(pattern *regexp.Regexp, repl string) NormalizeOption {
@@ -11,21 +22,414 @@ func ReplacePattern(pattern *regexp.Regexp, repl string) NormalizeOption {
 	}
 }
 
-var ReplaceTimestamps = func() NormalizeOption {
-	// timestampPattern will match a RFC3339 timestamp
-	timestampPattern := regexp.MustCompile(`([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)([.][0-9]+)?(([Zz])|([+|-]([01][0-9]|2[0-3]):[0-5][0-9]))`)
-	return ReplacePattern(timestampPattern, "0001-01-01T00:00:00Z")
-}()
+// ReplaceString replaces every occurrence of old in the body with repl. Unlike ReplacePattern, old is
+// matched literally so host names and other values containing dots need no escaping.
+func ReplaceString(old, repl string) NormalizeOption {
+	return func(resp *Response) {
+		resp.Body.String = strings.ReplaceAll(resp.Body.String, old, repl)
+	}
+}
+
+// StableIDs replaces each distinct value matched by pattern with a sequential placeholder, so the first
+// distinct ID in a cassette becomes 1, the second 2 and so on. Unlike ReplacePattern the mapping is shared by
+// every interaction in the cassette, so responses that refer to each other still agree. If pattern has a
+// capture group only the first group is replaced.
+func StableIDs(pattern *regexp.Regexp) ReplayOption {
+	return func(c *config) {
+		c.stableIDs = append(c.stableIDs, pattern)
+	}
+}
+
+// stableIDs returns fresh normalizers for patterns, each numbering the IDs it has seen.
+func stableIDs(patterns []*regexp.Regexp) []NormalizeOption {
+	var opts []NormalizeOption
+	for _, pattern := range patterns {
+		pattern := pattern
+		ids := map[string]string{}
+		opts = append(opts, func(resp *Response) {
+			body := resp.Body.String
+			var b strings.Builder
+			last := 0
+			for _, match := range pattern.FindAllStringSubmatchIndex(body, -1) {
+				start, end := match[0], match[1]
+				if len(match) > 2 && match[2] >= 0 {
+					start, end = match[2], match[3]
+				}
+				id, ok := ids[body[start:end]]
+				if !ok {
+					id = strconv.Itoa(len(ids) + 1)
+					ids[body[start:end]] = id
+				}
+				b.WriteString(body[last:start])
+				b.WriteString(id)
+				last = end
+			}
+			b.WriteString(body[last:])
+			resp.Body.String = b.String()
+		})
+	}
+	return opts
+}
+
+// timestampPattern will match a RFC3339 timestamp
+var timestampPattern = regexp.MustCompile(`([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)([.][0-9]+)?(([Zz])|([+|-]([01][0-9]|2[0-3]):[0-5][0-9]))`)
+
+// uuidPattern will match a hex representation of a v4 uuid/guid
+var uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// ReplaceTimestampsWith replaces every RFC3339 timestamp in the body with placeholder.
+func ReplaceTimestampsWith(placeholder time.Time) NormalizeOption {
+	return ReplacePattern(timestampPattern, placeholder.Format(time.RFC3339Nano))
+}
+
+// ReplaceUUIDsWith replaces every v4 UUID in the body with placeholder.
+func ReplaceUUIDsWith(placeholder string) NormalizeOption {
+	return ReplacePattern(uuidPattern, placeholder)
+}
+
+var ReplaceTimestamps = ReplaceTimestampsWith(time.Time{})
+
+var ReplaceUUIDs = ReplaceUUIDsWith("11111111-2222-3333-4444-000000000000")
+
+// SortJSONArrays sorts the arrays found at paths in JSON bodies so that element order does not matter
+// when comparing. Paths are simple JSONPath expressions such as $.items or $.data[*].tags; with no paths
+// every array in the body is sorted.
+func SortJSONArrays(paths ...string) NormalizeOption {
+	return func(resp *Response) {
+		if !isJSON(resp.Headers) {
+			return
+		}
+		decoded, ok := decodeJSON(resp.Body.String)
+		if !ok {
+			return
+		}
+		if len(paths) == 0 {
+			decoded = walkJSON(decoded, sortJSONArray)
+		}
+		for _, path := range paths {
+			decoded = walkJSONPath(decoded, parseJSONPath(path), sortJSONArray)
+		}
+		if encoded, ok := encodeJSON(decoded); ok {
+			resp.Body.String = encoded
+		}
+	}
+}
+
+// CompareJSONFields compares JSON bodies by the fields at paths alone, ignoring the rest of the body, for large
+// responses where only a few fields matter. Paths are the simple JSONPath expressions SortJSONArrays accepts.
+// Only the comparison is affected, -overwrite still records the whole body.
+func CompareJSONFields(paths ...string) NormalizeOption {
+	return func(resp *Response) {
+		if !isJSON(resp.Headers) {
+			return
+		}
+		decoded, ok := decodeJSON(resp.Body.String)
+		if !ok {
+			return
+		}
+		fields := make(map[string][]any, len(paths))
+		for _, path := range paths {
+			values := []any{}
+			walkJSONPath(decoded, parseJSONPath(path), func(value any) any {
+				values = append(values, value)
+				return value
+			})
+			fields[path] = values
+		}
+		if encoded, ok := encodeJSON(fields); ok {
+			resp.Body.String = encoded
+		}
+	}
+}
+
+// TreatNullAsMissing removes fields whose value is null from the objects in JSON bodies, so that a field sent as
+// null compares equal to one that is left out.
+func TreatNullAsMissing() NormalizeOption {
+	return func(resp *Response) {
+		if !isJSON(resp.Headers) {
+			return
+		}
+		decoded, ok := decodeJSON(resp.Body.String)
+		if !ok {
+			return
+		}
+		decoded = walkJSON(decoded, func(value any) any {
+			if object, ok := value.(map[string]any); ok {
+				for key, field := range object {
+					if field == nil {
+						delete(object, key)
+					}
+				}
+			}
+			return value
+		})
+		if encoded, ok := encodeJSON(decoded); ok {
+			resp.Body.String = encoded
+		}
+	}
+}
+
+// maxNestedJSONDepth limits how many levels of JSON encoded strings NormalizeNestedJSON will unwrap.
+const maxNestedJSONDepth = 8
+
+// NormalizeNestedJSON canonicalizes string values in JSON responses that are themselves JSON documents, such as
+// the payloads of webhook envelopes, so that differences in their whitespace or key order are ignored.
+func NormalizeNestedJSON() NormalizeOption {
+	return func(resp *Response) {
+		if !isJSON(resp.Headers) {
+			return
+		}
+		decoded, ok := decodeJSON(resp.Body.String)
+		if !ok {
+			return
+		}
+		decoded = walkJSON(decoded, func(value any) any {
+			return nestedJSON(value, maxNestedJSONDepth)
+		})
+		if encoded, ok := encodeJSON(decoded); ok {
+			resp.Body.String = encoded
+		}
+	}
+}
+
+// nestedJSON replaces a string holding a JSON object or array with its compact encoding, unwrapping
+// strings within it up to depth levels deep.
+func nestedJSON(value any, depth int) any {
+	s, ok := value.(string)
+	if !ok || depth == 0 || !looksLikeJSON(s) {
+		return value
+	}
+	decoded, _ := decodeJSON(s)
+	decoded = walkJSON(decoded, func(value any) any {
+		return nestedJSON(value, depth-1)
+	})
+	return encodeCompact(decoded)
+}
+
+// WhenStatus applies opt only to responses with one of the given status codes.
+func WhenStatus(codes []int, opt NormalizeOption) NormalizeOption {
+	return func(resp *Response) {
+		if slices.Contains(codes, resp.Status.Code) {
+			opt(resp)
+		}
+	}
+}
+
+// TransformHeader replaces the values of the named header with the result of fn before comparison.
+// Returning no values removes the header.
+func TransformHeader(name string, fn func(values []string) []string) NormalizeOption {
+	return func(resp *Response) {
+		key := http.CanonicalHeaderKey(name)
+		if values := fn(resp.Headers.Values(key)); len(values) > 0 {
+			resp.Headers[key] = values
+		} else {
+			delete(resp.Headers, key)
+		}
+	}
+}
+
+// IgnoreHeaders removes the named headers before comparison.
+func IgnoreHeaders(names ...string) NormalizeOption {
+	return eachHeader(names, func(values []string) []string {
+		return nil
+	})
+}
+
+// CaseInsensitiveHeaders lowercases the values of the named headers so that they compare without regard to case.
+func CaseInsensitiveHeaders(names ...string) NormalizeOption {
+	return eachHeader(names, func(values []string) []string {
+		for i := range values {
+			values[i] = strings.ToLower(values[i])
+		}
+		return values
+	})
+}
+
+// eachHeader applies TransformHeader with fn to each of the named headers.
+func eachHeader(names []string, fn func(values []string) []string) NormalizeOption {
+	return func(resp *Response) {
+		for _, name := range names {
+			TransformHeader(name, fn)(resp)
+		}
+	}
+}
+
+// NormalizeStaticFileHeaders zeroes the Last-Modified and Date headers set by handlers that use http.ServeContent
+// or http.ServeFile, keeping ETag, Accept-Ranges and Content-Range, which describe what was served, so that the
+// modification time of the file does not matter. Recorded Range headers are sent to the handler like any other,
+// so partial responses can be replayed too.
+func NormalizeStaticFileHeaders() NormalizeOption {
+	return eachHeader([]string{"Last-Modified", "Date"}, func(values []string) []string {
+		for i := range values {
+			values[i] = time.Time{}.Format(http.TimeFormat)
+		}
+		return values
+	})
+}
+
+// NormalizeCookies zeroes the Expires and Max-Age attributes of every Set-Cookie header and replaces the
+// values of the named cookies, such as session identifiers, with a placeholder. Cookie names and other
+// attributes are kept so that they are still compared.
+func NormalizeCookies(names ...string) NormalizeOption {
+	return func(resp *Response) {
+		cookies := resp.Headers.Values("Set-Cookie")
+		for i, cookie := range cookies {
+			parts := strings.Split(cookie, ";")
+			for j, part := range parts {
+				key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+				switch {
+				case j == 0:
+					if slices.Contains(names, key) {
+						value = "session"
+					}
+				case strings.EqualFold(key, "Expires"):
+					value = time.Time{}.Format(http.TimeFormat)
+				case strings.EqualFold(key, "Max-Age"):
+					value = "0"
+				default:
+					parts[j] = strings.TrimSpace(part)
+					continue
+				}
+				parts[j] = key + "=" + value
+			}
+			cookies[i] = strings.Join(parts, "; ")
+		}
+	}
+}
+
+// grpcMetadataPrefix starts the name of headers a gRPC gateway translates from gRPC metadata.
+const grpcMetadataPrefix = "Grpc-Metadata-"
+
+// defaultVolatileMetadata is the gRPC metadata NormalizeGrpcMetadata removes when given no names, because it
+// carries request or tracing identifiers that change on every run.
+var defaultVolatileMetadata = []string{"x-request-id", "traceparent", "tracestate", "grpc-trace-bin"}
+
+// NormalizeGrpcMetadata canonicalizes the Grpc-Metadata- headers a gRPC gateway translates from gRPC metadata,
+// lowercasing and sorting their values, and removes the named metadata, such as x-request-id, whose values change
+// between runs. Names are given without the prefix. With no names x-request-id, traceparent, tracestate and
+// grpc-trace-bin are removed.
+func NormalizeGrpcMetadata(volatile ...string) NormalizeOption {
+	if len(volatile) == 0 {
+		volatile = defaultVolatileMetadata
+	}
+	return func(resp *Response) {
+		for name := range resp.Headers {
+			key := http.CanonicalHeaderKey(name)
+			if !strings.HasPrefix(key, grpcMetadataPrefix) {
+				continue
+			}
+			metadata := strings.TrimPrefix(key, grpcMetadataPrefix)
+			TransformHeader(name, func(values []string) []string {
+				if slices.ContainsFunc(volatile, func(v string) bool { return strings.EqualFold(v, metadata) }) {
+					return nil
+				}
+				for i := range values {
+					values[i] = strings.ToLower(values[i])
+				}
+				sort.Strings(values)
+				return values
+			})(resp)
+		}
+	}
+}
+
+// NormalizeVary lowercases, sorts and deduplicates the header names listed by the Vary header, merging them into
+// a single value, both when comparing and in the responses recorded by -overwrite and Recorder.
+func NormalizeVary() ReplayOption {
+	return func(c *config) {
+		c.normalizers = append(c.normalizers, TransformHeader("Vary", canonicalVary))
+		c.canonicalVary = true
+	}
+}
+
+// correlatedRequestID is the request ID CorrelatedRequestID sends with every replayed request.
+const correlatedRequestID = "00000000-0000-4000-8000-000000000000"
+
+// CorrelatedRequestID sends the named header with a fixed request ID on every replayed request, replacing any that
+// was recorded, for handlers that echo the ID of the request into the response or generate one when it is
+// missing. When comparing, the values of the same response header and any copies of the ID in the body are
+// replaced with a placeholder.
+func CorrelatedRequestID(name string) ReplayOption {
+	name = http.CanonicalHeaderKey(name)
+	placeholder := "{" + strings.ToLower(name) + "}"
+	return func(c *config) {
+		c.requestIDHeader = name
+		c.normalizers = append(c.normalizers, TransformHeader(name, func(values []string) []string {
+			for i := range values {
+				values[i] = placeholder
+			}
+			return values
+		}), ReplaceString(correlatedRequestID, placeholder))
+	}
+}
+
+// canonicalVary returns the header names listed in values as a single sorted value.
+func canonicalVary(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return []string{strings.Join(names, ", ")}
+}
+
+var registry = struct {
+	sync.RWMutex
+	normalizers map[string][]NormalizeOption
+	comparers   map[string]func(recorded, actual string) bool
+}{normalizers: map[string][]NormalizeOption{}, comparers: map[string]func(recorded, actual string) bool{}}
 
-var ReplaceUUIDs = func() NormalizeOption {
-	// uuidPattern will match a hex representation of a v4 uuid/guid
-	var uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}`)
-	return ReplacePattern(uuidPattern, "11111111-2222-3333-4444-000000000000")
-}()
+// RegisterNormalizer arranges for opt to be applied to every response with a Content-Type of mediaType.
+// Registered normalizers run before any options passed to Replay.
+func RegisterNormalizer(mediaType string, opt NormalizeOption) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.normalizers[mediaType] = append(registry.normalizers[mediaType], opt)
+}
+
+// registered returns the normalizers registered for the Content-Type in h.
+func registered(h http.Header) []NormalizeOption {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	return slices.Clone(registry.normalizers[mediaType])
+}
+
+// RegisterComparer arranges for fn to decide whether the bodies of a recorded and an actual response with a
+// Content-Type of mediaType are equal, once both are normalized, instead of comparing them byte for byte. When fn
+// reports true the Content-Length of the responses is not compared either. Registering a comparer for a media
+// type replaces any registered before.
+func RegisterComparer(mediaType string, fn func(recorded, actual string) bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.comparers[mediaType] = fn
+}
+
+// registeredComparer returns the comparer registered for the Content-Type in h, or nil if there is none.
+func registeredComparer(h http.Header) func(recorded, actual string) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.comparers[mediaType]
+}
 
-// normalize clones response and applies opts to strip out anything that changes between runs but does
-// not affect the equality of the responses.
-func normalize(response *Response, opts []NormalizeOption) *Response {
+// Normalize returns a copy of response with the normalizers registered for its Content-Type and then opts
+// applied, stripping out anything that changes between runs but does not affect the equality of the
+// responses. response itself is not modified.
+func Normalize(response *Response, opts ...NormalizeOption) *Response {
 	if response == nil {
 		return nil
 	}
@@ -33,19 +437,49 @@ func normalize(response *Response, opts []NormalizeOption) *Response {
 	clone := &Response{}
 	*clone = *response
 
-	body := response.Body.String
-
-	response.Body.String = body
-
 	clone.Headers = response.Headers.Clone()
 
 	if clone.Headers == nil {
 		clone.Headers = http.Header{}
 	}
 
+	for _, opt := range registered(clone.Headers) {
+		opt(clone)
+	}
+
 	for _, opt := range opts {
 		opt(clone)
 	}
 
 	return clone
 }
+
+// NormalizeWithReport is Normalize, also returning the indices of the options in opts that changed the
+// response, for documenting what normalization masks or finding options that match more than intended.
+// Normalizers registered for the Content-Type are applied first and are not reported.
+func NormalizeWithReport(response *Response, opts ...NormalizeOption) (*Response, []int) {
+	clone := Normalize(response)
+	if clone == nil {
+		return nil, nil
+	}
+	var changed []int
+	for i, opt := range opts {
+		before := *clone
+		before.Headers = clone.Headers.Clone()
+		before.Chunks = slices.Clone(clone.Chunks)
+		before.HeaderOrder = slices.Clone(clone.HeaderOrder)
+		opt(clone)
+		if !reflect.DeepEqual(&before, clone) {
+			changed = append(changed, i)
+		}
+	}
+	return clone, changed
+}
+
+// AssertDeterministic fails the test if applying opt to two copies of sample gives different responses, which
+// would make cassettes flap, for example when a custom option uses the current time in a replacement.
+func AssertDeterministic(t testing.TB, opt NormalizeOption, sample *Response) {
+	t.Helper()
+	first, second := Normalize(sample, opt), Normalize(sample, opt)
+	require.Equal(t, first, second, "normalize option is not deterministic")
+}
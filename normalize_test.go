@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"github.com/simon-engledew/go-vcr"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"regexp"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestReplaceTimestamps(t *testing.T) {
@@ -21,3 +25,188 @@ func TestReplaceUUIDs(t *testing.T) {
 	fmt.Println(resp.Body.String)
 	require.NotEqual(t, resp.Body.String, "UUID 123e4567-e89b-42d3-a456-426614174000")
 }
+
+func TestSortJSONArrays(t *testing.T) {
+	jsonResponse := func(body string) *vcr.Response {
+		resp := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+		resp.Body.String = body
+		return resp
+	}
+
+	a := jsonResponse(`{"items": [3, 1, 2], "tags": ["b", "a"]}`)
+	b := jsonResponse(`{"items": [1, 2, 3], "tags": ["b", "a"]}`)
+	vcr.SortJSONArrays("$.items")(a)
+	vcr.SortJSONArrays("$.items")(b)
+	require.Equal(t, a.Body.String, b.Body.String)
+	require.Contains(t, a.Body.String, `"b",`)
+
+	a = jsonResponse(`[{"id": 2, "tags": ["y", "x"]}, {"id": 1}]`)
+	b = jsonResponse(`[{"id": 1}, {"id": 2, "tags": ["x", "y"]}]`)
+	vcr.SortJSONArrays()(a)
+	vcr.SortJSONArrays()(b)
+	require.Equal(t, a.Body.String, b.Body.String)
+}
+
+func TestCompareJSONFields(t *testing.T) {
+	recorded := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+	recorded.Body.String = `{"id": 1, "status": "paid", "items": [{"sku": "a", "price": 1}], "etag": "x"}`
+	actual := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+	actual.Body.String = `{"id": 1, "status": "paid", "items": [{"sku": "a", "price": 2}], "etag": "yz"}`
+
+	opt := vcr.CompareJSONFields("$.status", "$.items[*].sku")
+	require.Equal(t, vcr.Normalize(recorded, opt), vcr.Normalize(actual, opt))
+	require.JSONEq(t, `{"$.status": ["paid"], "$.items[*].sku": ["a"]}`, vcr.Normalize(actual, opt).Body.String)
+}
+
+func TestTreatNullAsMissing(t *testing.T) {
+	recorded := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+	recorded.Body.String = `{"id": 1, "nickname": null, "items": [{"note": null}]}`
+	actual := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+	actual.Body.String = `{"id": 1, "items": [{}]}`
+
+	require.Equal(t, vcr.Normalize(recorded, vcr.TreatNullAsMissing()), vcr.Normalize(actual, vcr.TreatNullAsMissing()))
+}
+
+func TestNormalizeNestedJSON(t *testing.T) {
+	jsonResponse := func(body string) *vcr.Response {
+		resp := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+		resp.Body.String = body
+		return resp
+	}
+
+	a := jsonResponse(`{"event": "created", "payload": "{\"id\": 1, \"data\": \"{\\\"b\\\": 2, \\\"a\\\": 1}\"}"}`)
+	b := jsonResponse(`{"event": "created", "payload": "{\"data\":\"{\\\"a\\\":1,\\\"b\\\":2}\",\"id\":1}"}`)
+	vcr.NormalizeNestedJSON()(a)
+	vcr.NormalizeNestedJSON()(b)
+	require.Equal(t, a.Body.String, b.Body.String)
+
+	plain := jsonResponse(`{"message": "{not json}"}`)
+	vcr.NormalizeNestedJSON()(plain)
+	require.Contains(t, plain.Body.String, `"{not json}"`)
+}
+
+func TestWhenStatus(t *testing.T) {
+	scrub := vcr.WhenStatus([]int{500}, vcr.ReplacePattern(regexp.MustCompile(`goroutine [0-9]+`), "goroutine 1"))
+
+	failed := &vcr.Response{}
+	failed.Status.Code = 500
+	failed.Body.String = "panic: goroutine 42"
+	scrub(failed)
+	require.Equal(t, "panic: goroutine 1", failed.Body.String)
+
+	ok := &vcr.Response{}
+	ok.Status.Code = 200
+	ok.Body.String = "goroutine 42"
+	scrub(ok)
+	require.Equal(t, "goroutine 42", ok.Body.String)
+}
+
+func TestCaseInsensitiveHeaders(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{
+		"Content-Type": {"text/HTML; charset=UTF-8"},
+		"X-Token":      {"AbC"},
+	}}
+	vcr.CaseInsensitiveHeaders("content-type")(resp)
+	require.Equal(t, "text/html; charset=utf-8", resp.Headers.Get("Content-Type"))
+	require.Equal(t, "AbC", resp.Headers.Get("X-Token"))
+}
+
+func TestNormalizeCookies(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{"Set-Cookie": {
+		"sid=f00dcafe; Path=/; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Max-Age=3600; HttpOnly; Secure",
+		"theme=dark",
+	}}}
+	vcr.NormalizeCookies("sid")(resp)
+	require.Equal(t, []string{
+		"sid=session; Path=/; Expires=Mon, 01 Jan 0001 00:00:00 GMT; Max-Age=0; HttpOnly; Secure",
+		"theme=dark",
+	}, resp.Headers.Values("Set-Cookie"))
+}
+
+func TestNormalizeGrpcMetadata(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{
+		"Grpc-Metadata-X-Request-Id": {"f00dcafe"},
+		"Grpc-Metadata-Region":       {"US-East", "EU-West"},
+		"X-Request-Id":               {"f00dcafe"},
+	}}
+	vcr.NormalizeGrpcMetadata()(resp)
+	require.Equal(t, http.Header{
+		"Grpc-Metadata-Region": {"eu-west", "us-east"},
+		"X-Request-Id":         {"f00dcafe"},
+	}, resp.Headers)
+}
+
+func TestAssertDeterministic(t *testing.T) {
+	sample := &vcr.Response{Headers: http.Header{"Content-Type": {"application/json"}}}
+	sample.Body.String = `{"id": "f47ac10b-58cc-4372-a567-0e02b2c3d479", "tags": ["b", "a"]}`
+	vcr.AssertDeterministic(t, vcr.ReplaceUUIDs, sample)
+	vcr.AssertDeterministic(t, vcr.SortJSONArrays(), sample)
+}
+
+func TestNormalizeWithReport(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{"Date": {"Wed, 14 Oct 2026 04:00:00 GMT"}}}
+	resp.Body.String = "created at 2026-10-14T04:00:00Z"
+
+	normalized, changed := vcr.NormalizeWithReport(resp, vcr.ReplaceUUIDs, vcr.ReplaceTimestamps, vcr.IgnoreHeaders("Date", "Vary"))
+	require.Equal(t, []int{1, 2}, changed)
+	require.Equal(t, "created at 0001-01-01T00:00:00Z", normalized.Body.String)
+	require.Equal(t, "created at 2026-10-14T04:00:00Z", resp.Body.String)
+}
+
+func TestNormalizeVary(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin, Accept-Encoding")
+		w.Header().Add("Vary", "origin")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	want := vcr.Response{Headers: http.Header{"Vary": {"accept-encoding, origin"}}}
+	want.Status.Code = http.StatusNoContent
+
+	vcr.Expect(t, vcr.RecordedRequest{Method: "GET", URI: "http://localhost/hello-world"}, want, mux, vcr.NormalizeVary())
+}
+
+func TestReplaceString(t *testing.T) {
+	resp := &vcr.Response{}
+	resp.Body.String = `{"self": "http://ci.example.com:8080/users/1"}`
+	vcr.ReplaceString("http://ci.example.com:8080", "http://localhost")(resp)
+	require.Equal(t, `{"self": "http://localhost/users/1"}`, resp.Body.String)
+}
+
+func TestTransformHeader(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{
+		"Vary": {"Origin", "Accept"},
+		"Date": {"Wed, 14 Oct 2026 04:00:00 GMT"},
+	}}
+	vcr.TransformHeader("vary", func(values []string) []string {
+		sort.Strings(values)
+		return values
+	})(resp)
+	vcr.IgnoreHeaders("Date")(resp)
+	require.Equal(t, http.Header{"Vary": {"Accept", "Origin"}}, resp.Headers)
+}
+
+func TestReplaceWith(t *testing.T) {
+	resp := &vcr.Response{}
+	resp.Body.String = "123e4567-e89b-42d3-a456-426614174000 at 1990-12-31T23:59:59+01:00"
+	vcr.ReplaceUUIDsWith("00000000-0000-0000-0000-000000000000")(resp)
+	vcr.ReplaceTimestampsWith(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))(resp)
+	require.Equal(t, "00000000-0000-0000-0000-000000000000 at 2000-01-01T00:00:00Z", resp.Body.String)
+
+	resp.Body.String = "1990-12-31T23:59:59Z"
+	vcr.ReplaceTimestamps(resp)
+	require.Equal(t, "0001-01-01T00:00:00Z", resp.Body.String)
+}
+
+func TestNormalize(t *testing.T) {
+	resp := &vcr.Response{Headers: http.Header{"Date": {"Sun, 09 Apr 2023 13:05:58 GMT"}}}
+	resp.Body.String = "created 2023-04-09T13:05:58Z"
+
+	normalized := vcr.Normalize(resp, vcr.ReplaceTimestamps, vcr.IgnoreHeaders("Date"))
+	require.Equal(t, "created 0001-01-01T00:00:00Z", normalized.Body.String)
+	require.Empty(t, normalized.Headers)
+
+	require.Equal(t, "created 2023-04-09T13:05:58Z", resp.Body.String)
+	require.Equal(t, "Sun, 09 Apr 2023 13:05:58 GMT", resp.Headers.Get("Date"))
+}
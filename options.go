@@ -0,0 +1,432 @@
+package vcr
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Option configures a replay. NormalizeOption and ReplayOption are both Options.
+type Option interface {
+	apply(*config)
+}
+
+// ReplayOption changes how interactions are replayed and compared.
+type ReplayOption func(*config)
+
+func (o ReplayOption) apply(c *config) {
+	o(c)
+}
+
+type config struct {
+	normalizers        []NormalizeOption
+	compareHTTPVersion bool
+	recordFlushes      bool
+	matchers           []Matcher
+	requireOrder       bool
+	externalBodies     bool
+	bodyThreshold      int
+	bodyDir            string
+	noNewHeaders       bool
+	timeout            time.Duration
+	remoteAddr         string
+	tls                *tls.ConnectionState
+	stableIDs          []*regexp.Regexp
+	canonicalJSON      bool
+	streaming          bool
+	warnContentType    bool
+	schemas            []schemaCheck
+	checkContentLength bool
+	moduleRoot         string
+	testPath           string
+	terminalRedirects  bool
+	deniedHeaders      []string
+	allowedHeaders     []string
+	hashBody           bool
+	rewriteURI         func(*url.URL) *url.URL
+	compressBodies     bool
+	compressThreshold  int
+	includeTags        []string
+	excludeTags        []string
+	delays             bool
+	comparisonOnly     bool
+	ignoreReqHeaders   []string
+	prettyJSON         bool
+	headerSubset       bool
+	banner             func(test string) string
+	rawJSON            []string
+	bodyConsumed       bool
+	regenerate         bool
+	queryParams        url.Values
+	orderedHeaders     bool
+	middleware         []func(http.Handler) http.Handler
+	sortInteractions   bool
+	protoMajor         int
+	protoMinor         int
+	beforeOverwrite    []func(path string, before, after *Cassette) error
+	statusOnly         bool
+	duplicateKeys      bool
+	base64URL          bool
+	recordedBy         bool
+	headerTolerance    map[string]float64
+	idempotent         bool
+	cookies            []*http.Cookie
+	freezeDate         bool
+	allowUnmatched     bool
+	recordedWith       string
+	canonicalVary      bool
+	canonicalMultipart bool
+	maxBodySize        int
+	limitBodySize      bool
+	deadline           time.Duration
+	capture1xx         bool
+	canonicalURI       bool
+	secretPatterns     []*regexp.Regexp
+	backoffClock       func() time.Time
+	contentCategory    bool
+	wireJSON           bool
+	truncateBodies     bool
+	truncateAt         int
+	requiredHeaders    []string
+	requestIDHeader    string
+	recordHijacked     bool
+	freshHandler       func() http.Handler
+	gzipHash           bool
+	captureLog         *bytes.Buffer
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{protoMajor: 1, protoMinor: 1}
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+	return cfg
+}
+
+// WithStatusOnly only checks that each interaction returns the recorded status code, skipping the recording,
+// normalization and comparison of headers and bodies, for fast smoke tests across many cassettes. Cassettes
+// are never changed, even with -overwrite.
+func WithStatusOnly() ReplayOption {
+	return func(c *config) {
+		c.statusOnly = true
+	}
+}
+
+// CompareHTTPVersion treats a change in the recorded HTTP version as a modification. The version is always
+// recorded but is ignored when comparing by default.
+func CompareHTTPVersion() ReplayOption {
+	return func(c *config) {
+		c.compareHTTPVersion = true
+	}
+}
+
+// RecordFlushes replays with a recorder that notes the size of each chunk the handler flushes, so that
+// a cassette shows whether a handler streams its response. The chunks are stored but never compared.
+func RecordFlushes() ReplayOption {
+	return func(c *config) {
+		c.recordFlushes = true
+	}
+}
+
+// OrderedHeaders records the order in which the handler adds response headers and fails the comparison if it
+// changes. The order is taken from the handler's calls to Header, so each header should be set with its own call
+// to w.Header().
+func OrderedHeaders() ReplayOption {
+	return func(c *config) {
+		c.orderedHeaders = true
+	}
+}
+
+// ExternalBodies makes overwrite store response bodies larger than threshold bytes in separate files
+// under dir, which is relative to the cassette, instead of inline in the YAML.
+func ExternalBodies(threshold int, dir string) ReplayOption {
+	return func(c *config) {
+		c.externalBodies = true
+		c.bodyThreshold = threshold
+		c.bodyDir = dir
+	}
+}
+
+// CompressBodies stores response bodies larger than threshold bytes gzipped and base64 encoded with the GZIP
+// encoding when a cassette is written, keeping the rest of the cassette readable. Compressed bodies are
+// decompressed when the cassette is read. Without this option bodies that were compressed stay compressed.
+func CompressBodies(threshold int) ReplayOption {
+	return func(c *config) {
+		c.compressBodies = true
+		c.compressThreshold = threshold
+	}
+}
+
+// Base64URLBodies stores binary response bodies with the BASE64URL encoding, the URL-safe base64 alphabet
+// without padding, instead of standard base64 when a cassette is written, for tools that expect it. Bodies
+// stored either way are decoded when the cassette is read. Without this option BASE64URL bodies stay that way.
+func Base64URLBodies() ReplayOption {
+	return func(c *config) {
+		c.base64URL = true
+	}
+}
+
+// WithCookies adds cookies to every replayed request, after any recorded with the request, for handlers that
+// read r.Cookies such as those behind session middleware.
+func WithCookies(cookies []*http.Cookie) ReplayOption {
+	return func(c *config) {
+		c.cookies = append(c.cookies, cookies...)
+	}
+}
+
+// WithTimeout fails a replay when the handler takes longer than d to serve an interaction, instead of
+// hanging until the test binary times out. The request context is cancelled when the deadline passes and the
+// replay waits for the handler to return, so handlers must give up once r.Context() is done.
+func WithTimeout(d time.Duration) ReplayOption {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithDeadline gives the context of each replayed request a deadline d after the interaction starts, for handlers
+// that look at ctx.Deadline to decide how much work to do. Unlike WithTimeout the replay does not fail when the
+// deadline passes, the context is only cancelled if the handler is still running.
+func WithDeadline(d time.Duration) ReplayOption {
+	return func(c *config) {
+		c.deadline = d
+	}
+}
+
+// WithDelays waits for the delay_ms recorded with each interaction before serving its request, to simulate
+// a slow upstream.
+func WithDelays() ReplayOption {
+	return func(c *config) {
+		c.delays = true
+	}
+}
+
+// WithProto replays requests with the given HTTP version instead of HTTP/1.1, so that handlers that check
+// r.ProtoAtLeast can be tested. Responses are recorded with the same version.
+func WithProto(major, minor int) ReplayOption {
+	return func(c *config) {
+		c.protoMajor = major
+		c.protoMinor = minor
+	}
+}
+
+// WithRemoteAddr sets the RemoteAddr of replayed requests. Interactions can override it with remote_addr.
+func WithRemoteAddr(addr string) ReplayOption {
+	return func(c *config) {
+		c.remoteAddr = addr
+	}
+}
+
+// WithTLS makes replayed requests appear to have arrived over a TLS connection with the given state.
+func WithTLS(state *tls.ConnectionState) ReplayOption {
+	return func(c *config) {
+		c.tls = state
+	}
+}
+
+// CanonicalJSON stores any response body that is a JSON object or array with sorted keys and consistent
+// indentation, even when the Content-Type is not application/json, so the committed cassette does not
+// churn. This changes what is written to the cassette; normalize options only affect comparison.
+func CanonicalJSON() ReplayOption {
+	return func(c *config) {
+		c.canonicalJSON = true
+	}
+}
+
+// PrettyStoreJSON records every response with a JSON media type, such as application/problem+json, indented with
+// two spaces so that cassettes are readable whatever the handler emits. Bodies with a Content-Type of exactly
+// application/json are always stored this way.
+func PrettyStoreJSON() ReplayOption {
+	return func(c *config) {
+		c.prettyJSON = true
+	}
+}
+
+// StoreWireJSON records JSON bodies exactly as the handler wrote them, with its field order and whitespace, instead
+// of re-encoding them with sorted keys and indentation, so that the cassette shows what the handler emits. They are
+// still compared in the re-encoded form, so a handler that reorders its struct fields does not fail a replay and
+// -overwrite only rewrites the body when its contents change.
+func StoreWireJSON() ReplayOption {
+	return func(c *config) {
+		c.wireJSON = true
+	}
+}
+
+// NoJSONNormalize stores and compares JSON bodies returned for requests whose path matches pattern, using the
+// syntax of path.Match, byte for byte instead of re-encoding them. Use it for endpoints whose formatting matters.
+func NoJSONNormalize(pattern string) ReplayOption {
+	return func(c *config) {
+		c.rawJSON = append(c.rawJSON, pattern)
+	}
+}
+
+// WithRegenerate makes -overwrite discard every recorded response, along with any comments, and record the whole
+// cassette again from its requests, instead of only updating the responses that changed. It has no effect
+// without -overwrite.
+func WithRegenerate() ReplayOption {
+	return func(c *config) {
+		c.regenerate = true
+	}
+}
+
+// BeforeOverwrite calls fn with the cassette as it was read and as it is about to be written whenever -overwrite
+// replaces the cassette at path. Returning an error fails the test and leaves the cassette untouched, which can
+// enforce policies such as never recording a server error. It has no effect with Streaming.
+func BeforeOverwrite(fn func(path string, before, after *Cassette) error) ReplayOption {
+	return func(c *config) {
+		c.beforeOverwrite = append(c.beforeOverwrite, fn)
+	}
+}
+
+// WithBanner replaces the "generated by" comment written at the top of an overwritten cassette with the result of
+// fn, which is given the path of the test. Each line becomes a comment and an empty result writes no banner.
+func WithBanner(fn func(test string) string) ReplayOption {
+	return func(c *config) {
+		c.banner = fn
+	}
+}
+
+// WithModuleRoot sets the directory that the test path in the generated-by banner is relative to, instead of
+// searching upwards for go.mod. This is needed when tests are not built from a Go module, such as with Bazel.
+func WithModuleRoot(dir string) ReplayOption {
+	return func(c *config) {
+		c.moduleRoot = dir
+	}
+}
+
+// WithTestPath sets the test path written in the generated-by banner, skipping discovery altogether.
+func WithTestPath(path string) ReplayOption {
+	return func(c *config) {
+		c.testPath = path
+	}
+}
+
+// WithRecordedBy makes -overwrite note on each interaction whose response changes the test that recorded it,
+// as found for the banner, so that the interactions of a cassette shared by many tests can be traced back to them.
+func WithRecordedBy() ReplayOption {
+	return func(c *config) {
+		c.recordedBy = true
+	}
+}
+
+// WithCaptureWriter stores what the handler writes to buf while serving an interaction as the log of the
+// interaction when -overwrite records its response, so that reviewers can see what happened. Route the handler's
+// logger to buf; it is reset before each interaction. Logs are never compared.
+func WithCaptureWriter(buf *bytes.Buffer) ReplayOption {
+	return func(c *config) {
+		c.captureLog = buf
+	}
+}
+
+// FreezeDateHeader replaces the value of the Date header of every recorded response with a fixed timestamp, so
+// that cassettes keep the header without it changing on every run. Unlike IgnoreHeaders("Date") the header
+// must still be present. It applies to Recorder as well.
+func FreezeDateHeader() ReplayOption {
+	return func(c *config) {
+		c.freezeDate = true
+	}
+}
+
+// Capture1xx records the interim 1xx responses a handler sends before its final response, such as 103 Early
+// Hints, and compares them. Without it they are left out of the recording and ignored.
+func Capture1xx() ReplayOption {
+	return func(c *config) {
+		c.capture1xx = true
+	}
+}
+
+// TruncateBody records only the first maxBytes of response bodies that are longer, followed by a marker giving the
+// number of bytes left out and the SHA-256 of the whole body, such as "...[truncated 12 bytes, sha256=...]".
+// Replays truncate in the same way, so a change anywhere in the body is still caught while the cassette keeps
+// only a readable prefix.
+func TruncateBody(maxBytes int) ReplayOption {
+	return func(c *config) {
+		c.truncateBodies = true
+		c.truncateAt = maxBytes
+	}
+}
+
+// RecordHijacked lets handlers hijack the connection, for example to upgrade it to a WebSocket, and records the
+// raw bytes they write to it, base64 encoded, as the body of a response marked hijacked. Nothing can be read
+// from the connection. Without it the writer given to handlers is not an http.Hijacker.
+func RecordHijacked() ReplayOption {
+	return func(c *config) {
+		c.recordHijacked = true
+	}
+}
+
+// HashBody stores only the SHA-256 of response bodies that do not have a textual Content-Type, and compares the
+// hashes instead of the contents. This keeps cassettes small for binary endpoints while still catching changes.
+func HashBody() ReplayOption {
+	return func(c *config) {
+		c.hashBody = true
+	}
+}
+
+// GzipBodyHash stores only the SHA-256 of the decompressed contents of responses with a Content-Encoding of gzip,
+// and compares the hashes, so that huge compressed endpoints are decompressed once per replay without being
+// kept in the cassette. Unlike HashBody the hash does not change with how the handler compresses the body.
+func GzipBodyHash() ReplayOption {
+	return func(c *config) {
+		c.gzipHash = true
+	}
+}
+
+// RewriteURI changes the URI of each recorded request before it is given to the handler, for example to strip
+// the host from a cassette recorded against a production service. Unlike a Matcher it changes what the
+// handler receives.
+func RewriteURI(fn func(*url.URL) *url.URL) ReplayOption {
+	return func(c *config) {
+		c.rewriteURI = fn
+	}
+}
+
+// AddQueryParams adds params to the query of every recorded request before it is given to the handler, keeping
+// the parameters that were recorded, so that one cassette can be replayed with different parameters.
+func AddQueryParams(params url.Values) ReplayOption {
+	return func(c *config) {
+		if c.queryParams == nil {
+			c.queryParams = url.Values{}
+		}
+		for key, values := range params {
+			c.queryParams[key] = append(c.queryParams[key], values...)
+		}
+	}
+}
+
+// WithTags replays only the tagged interactions that have one of the include tags, or every tagged interaction if
+// include is empty, and skips interactions with any of the exclude tags. Untagged interactions are always replayed.
+// Skipped interactions are left unchanged by -overwrite.
+func WithTags(include, exclude []string) ReplayOption {
+	return func(c *config) {
+		c.includeTags = include
+		c.excludeTags = exclude
+	}
+}
+
+// FreshHandler builds a new handler with factory before each interaction, wrapped in any WithMiddleware, so that
+// state the handler keeps cannot leak from one interaction into the next. The handler passed to Replay is not
+// used and may be nil. It has no effect on ReplayServer.
+func FreshHandler(factory func() http.Handler) ReplayOption {
+	return func(c *config) {
+		c.freshHandler = factory
+	}
+}
+
+// WithMiddleware wraps the handler in middleware before replaying, with the first middleware outermost, so that
+// the handler can be tested behind the same stack it runs behind in production.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) ReplayOption {
+	return func(c *config) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// withMiddleware wraps handler in the middleware configured with WithMiddleware.
+func withMiddleware(handler http.Handler, cfg *config) http.Handler {
+	for i := len(cfg.middleware) - 1; i >= 0; i-- {
+		handler = cfg.middleware[i](handler)
+	}
+	return handler
+}
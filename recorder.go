@@ -0,0 +1,343 @@
+package vcr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flushRecorder records the size of each chunk a streaming handler flushes.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	chunks  []int
+	flushed int
+}
+
+func (f *flushRecorder) Flush() {
+	f.chunks = append(f.chunks, f.Body.Len()-f.flushed)
+	f.flushed = f.Body.Len()
+	f.ResponseRecorder.Flush()
+}
+
+// Chunks returns the chunk sizes, including anything written after the last flush,
+// or nil if the handler never flushed.
+func (f *flushRecorder) Chunks() []int {
+	if len(f.chunks) == 0 {
+		return nil
+	}
+	if remaining := f.Body.Len() - f.flushed; remaining > 0 {
+		return append(f.chunks, remaining)
+	}
+	return f.chunks
+}
+
+// orderRecorder notes the order in which a handler adds response headers. http.Header is a map, so a header is
+// only seen the next time the handler calls Header, WriteHeader or Write; headers added between two such calls
+// are put in alphabetical order.
+type orderRecorder struct {
+	http.ResponseWriter
+	seen  map[string]bool
+	order []string
+}
+
+func (o *orderRecorder) observe() {
+	var added []string
+	for name := range o.ResponseWriter.Header() {
+		if !o.seen[name] {
+			o.seen[name] = true
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	o.order = append(o.order, added...)
+}
+
+func (o *orderRecorder) Header() http.Header {
+	o.observe()
+	return o.ResponseWriter.Header()
+}
+
+func (o *orderRecorder) WriteHeader(code int) {
+	o.observe()
+	o.ResponseWriter.WriteHeader(code)
+}
+
+func (o *orderRecorder) Write(b []byte) (int, error) {
+	o.observe()
+	return o.ResponseWriter.Write(b)
+}
+
+func (o *orderRecorder) Flush() {
+	if flusher, ok := o.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Order returns the headers in the order they were added, including any added since the last call.
+func (o *orderRecorder) Order() []string {
+	o.observe()
+	return o.order
+}
+
+// informationalRecorder keeps the interim 1xx responses a handler sends, such as 103 Early Hints, from the
+// ResponseRecorder, which would take the first of them to be the final response.
+type informationalRecorder struct {
+	http.ResponseWriter
+	responses []Informational
+}
+
+func (i *informationalRecorder) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		i.responses = append(i.responses, Informational{Code: code, Headers: i.ResponseWriter.Header().Clone()})
+		return
+	}
+	i.ResponseWriter.WriteHeader(code)
+}
+
+func (i *informationalRecorder) Flush() {
+	if flusher, ok := i.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// hijackRecorder lets a handler hijack the connection, keeping what it writes.
+type hijackRecorder struct {
+	http.ResponseWriter
+	conn *hijackedConn
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h.conn != nil {
+		return nil, nil, fmt.Errorf("vcr: the connection has already been hijacked")
+	}
+	h.conn = &hijackedConn{}
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func (h *hijackRecorder) Flush() {
+	if flusher, ok := h.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// hijackedConn is the connection a handler gets from hijackRecorder. Reads see the end of the stream and writes
+// are kept.
+type hijackedConn struct {
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *hijackedConn) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written.Write(b)
+}
+
+// Bytes returns everything written to the connection.
+func (c *hijackedConn) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.written.Bytes())
+}
+
+func (c *hijackedConn) Close() error                     { return nil }
+func (c *hijackedConn) LocalAddr() net.Addr              { return hijackedAddr{} }
+func (c *hijackedConn) RemoteAddr() net.Addr             { return hijackedAddr{} }
+func (c *hijackedConn) SetDeadline(time.Time) error      { return nil }
+func (c *hijackedConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *hijackedConn) SetWriteDeadline(time.Time) error { return nil }
+
+// hijackedAddr is the address of both ends of a hijackedConn.
+type hijackedAddr struct{}
+
+func (hijackedAddr) Network() string { return "vcr" }
+func (hijackedAddr) String() string  { return "replay" }
+
+// Recorder is an http.RoundTripper that passes requests on to another transport and records each
+// interaction, so that cassettes for client code can be captured from a real service. Interactions are
+// grouped into cassettes by a routing function and nothing is written until Save is called.
+//
+// A client that follows redirects makes a round trip for each hop, so the whole chain is recorded in order
+// and a Replayer can serve every hop in turn.
+type Recorder struct {
+	cfg       *config
+	transport http.RoundTripper
+	route     func(*http.Request) string
+
+	mu    sync.Mutex
+	paths []string
+	tapes map[string]*Cassette
+}
+
+// NewRecorder returns a Recorder that sends requests through transport, or http.DefaultTransport if it is nil,
+// and records them in the cassette at the path route returns for each request.
+func NewRecorder(route func(*http.Request) string, transport http.RoundTripper, opts ...Option) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		cfg:       newConfig(opts),
+		transport: transport,
+		route:     route,
+		tapes:     make(map[string]*Cassette),
+	}
+}
+
+// defaultDeniedHeaders are the request headers a Recorder drops unless DenyRequestHeaders or
+// AllowRequestHeaders says otherwise, because they usually carry credentials.
+var defaultDeniedHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// DenyRequestHeaders replaces the request headers a Recorder drops before writing a request to a cassette.
+// By default it drops Authorization, Cookie and X-Api-Key.
+func DenyRequestHeaders(names ...string) ReplayOption {
+	return func(c *config) {
+		c.deniedHeaders = append([]string{}, names...)
+	}
+}
+
+// AllowRequestHeaders makes a Recorder keep only the named request headers, dropping all others.
+func AllowRequestHeaders(names ...string) ReplayOption {
+	return func(c *config) {
+		c.allowedHeaders = append([]string{}, names...)
+	}
+}
+
+// recordedHeaders returns a copy of h without the headers cfg says must not be recorded.
+func recordedHeaders(h http.Header, cfg *config) http.Header {
+	h = h.Clone()
+	if cfg.allowedHeaders != nil {
+		allowed := http.Header{}
+		for _, name := range cfg.allowedHeaders {
+			if values := h.Values(name); values != nil {
+				allowed[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		return allowed
+	}
+	denied := defaultDeniedHeaders
+	if cfg.deniedHeaders != nil {
+		denied = cfg.deniedHeaders
+	}
+	for _, name := range denied {
+		h.Del(name)
+	}
+	return h
+}
+
+// ToCassette routes every request to the cassette at path.
+func ToCassette(path string) func(*http.Request) string {
+	return func(*http.Request) string {
+		return path
+	}
+}
+
+// RoundTrip sends r to the underlying transport and records the interaction. The reason phrase of the status
+// line is recorded as the status message, so that a Replayer answers with the upstream's own wording.
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	path := rec.route(r)
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := rec.transport.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(contents))
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method:  r.Method,
+			URI:     rec.uri(r),
+			Body:    string(body),
+			Headers: recordedHeaders(r.Header, rec.cfg),
+		}.toRequest(),
+		Response:   &Response{Headers: resp.Header.Clone(), Body: newBody(string(contents))},
+		RecordedAt: time.Now().UTC().Format(http.TimeFormat),
+	}
+	// the client has sent the trailers by the time the response arrives
+	if len(r.Trailer) > 0 {
+		interaction.Request.Trailers = r.Trailer.Clone()
+	}
+	interaction.Response.Status.Code = resp.StatusCode
+	if message := strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))); message != "" {
+		interaction.Response.Status.Message = &message
+	}
+	if rec.cfg.freezeDate {
+		freezeDate(interaction.Response.Headers)
+	}
+	if rec.cfg.canonicalVary {
+		TransformHeader("Vary", canonicalVary)(interaction.Response)
+	}
+	if rec.cfg.canonicalMultipart {
+		normalizeMultipart(interaction.Response)
+	}
+	version := fmt.Sprintf("%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+	interaction.Response.HttpVersion = &version
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	tape, ok := rec.tapes[path]
+	if !ok {
+		tape = &Cassette{}
+		rec.tapes[path] = tape
+		rec.paths = append(rec.paths, path)
+	}
+	tape.Interactions = append(tape.Interactions, interaction)
+
+	return resp, nil
+}
+
+// uri returns the URI of r as it is recorded.
+func (rec *Recorder) uri(r *http.Request) string {
+	if rec.cfg.canonicalURI {
+		return canonicalURI(r.URL.String())
+	}
+	return r.URL.String()
+}
+
+// Save writes each cassette that has recorded interactions, replacing any existing file.
+func (rec *Recorder) Save() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, path := range rec.paths {
+		tape := rec.tapes[path]
+		if rec.cfg.sortInteractions {
+			sortInteractions(tape)
+		}
+		if err := writeBodies(filepath.Dir(path), tape, rec.cfg); err != nil {
+			return err
+		}
+		if err := Save(path, tape); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	ours := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ours"))
+	}))
+	defer ours.Close()
+	vendor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("vendor"))
+	}))
+	defer vendor.Close()
+
+	dir := t.TempDir()
+	recorder := vcr.NewRecorder(func(r *http.Request) string {
+		if r.URL.Host == filepath.Base(vendor.URL) {
+			return filepath.Join(dir, "vendor.yml")
+		}
+		return filepath.Join(dir, "ours.yml")
+	}, nil)
+	client := &http.Client{Transport: recorder}
+
+	for _, uri := range []string{ours.URL + "/a", vendor.URL + "/b", ours.URL + "/c"} {
+		_, err := get(t, client, uri)
+		require.NoError(t, err)
+	}
+	require.NoError(t, recorder.Save())
+
+	tape, err := vcr.Load(filepath.Join(dir, "ours.yml"))
+	require.NoError(t, err)
+	require.Len(t, tape.Interactions, 2)
+	require.Equal(t, ours.URL+"/c", tape.Interactions[1].Request.URI)
+
+	tape, err = vcr.Load(filepath.Join(dir, "vendor.yml"))
+	require.NoError(t, err)
+	require.Len(t, tape.Interactions, 1)
+	require.Equal(t, "vendor", tape.Interactions[0].Response.Body.String)
+
+	body, err := get(t, &http.Client{Transport: vcr.NewReplayer(tape)}, vendor.URL+"/b")
+	require.NoError(t, err)
+	require.Equal(t, "vendor", body)
+}
+
+func TestRecorderDropsSecretHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	record := func(opts ...vcr.Option) http.Header {
+		path := filepath.Join(t.TempDir(), "headers.yml")
+		recorder := vcr.NewRecorder(vcr.ToCassette(path), nil, opts...)
+		request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer secret")
+		request.Header.Set("X-Api-Key", "secret")
+		request.Header.Set("Accept", "application/json")
+		resp, err := (&http.Client{Transport: recorder}).Do(request)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.NoError(t, recorder.Save())
+
+		tape, err := vcr.Load(path)
+		require.NoError(t, err)
+		return tape.Interactions[0].Request.Headers
+	}
+
+	require.Equal(t, http.Header{"Accept": {"application/json"}}, record())
+	require.Equal(t, http.Header{"Accept": {"application/json"}, "X-Api-Key": {"secret"}}, record(vcr.DenyRequestHeaders("Authorization")))
+	require.Equal(t, http.Header{"X-Api-Key": {"secret"}}, record(vcr.AllowRequestHeaders("x-api-key")))
+}
+
+func TestRecorderRedirectChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/authorize", http.StatusFound)
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/callback", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("logged in"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "redirects.yml")
+	recorder := vcr.NewRecorder(vcr.ToCassette(path), nil)
+	body, err := get(t, &http.Client{Transport: recorder}, server.URL+"/login")
+	require.NoError(t, err)
+	require.Equal(t, "logged in", body)
+	require.NoError(t, recorder.Save())
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	var uris []string
+	for _, interaction := range tape.Interactions {
+		uris = append(uris, interaction.Request.URI)
+	}
+	require.Equal(t, []string{server.URL + "/login", server.URL + "/authorize", server.URL + "/callback"}, uris)
+
+	body, err = get(t, &http.Client{Transport: vcr.NewReplayer(tape)}, server.URL+"/login")
+	require.NoError(t, err)
+	require.Equal(t, "logged in", body)
+}
+
+type quirkyTransport struct{}
+
+func (quirkyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "429 Enhance Your Calm",
+		StatusCode: http.StatusTooManyRequests,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    r,
+	}, nil
+}
+
+func TestRecorderStatusMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.yml")
+	recorder := vcr.NewRecorder(vcr.ToCassette(path), quirkyTransport{})
+	resp, err := (&http.Client{Transport: recorder}).Get("http://localhost/limited")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, recorder.Save())
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "Enhance Your Calm", *tape.Interactions[0].Response.Status.Message)
+
+	resp, err = (&http.Client{Transport: vcr.NewReplayer(tape)}).Get("http://localhost/limited")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "429 Enhance Your Calm", resp.Status)
+}
+
+func TestRecorderTrailers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte(r.Trailer.Get("X-Checksum")))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "trailers.yml")
+	recorder := vcr.NewRecorder(vcr.ToCassette(path), nil)
+	request, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("contents"))
+	require.NoError(t, err)
+	request.Trailer = http.Header{"X-Checksum": {"abc123"}}
+	request.ContentLength = -1
+	resp, err := (&http.Client{Transport: recorder}).Do(request)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, recorder.Save())
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, http.Header{"X-Checksum": {"abc123"}}, tape.Interactions[0].Request.Trailers)
+	require.Equal(t, "abc123", tape.Interactions[0].Response.Body.String)
+}
+
+func TestRecorderFreezeDateHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "date.yml")
+	recorder := vcr.NewRecorder(vcr.ToCassette(path), nil, vcr.FreezeDateHeader())
+	_, err := get(t, &http.Client{Transport: recorder}, server.URL)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Save())
+
+	tape, err := vcr.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "Mon, 01 Jan 0001 00:00:00 GMT", tape.Interactions[0].Response.Headers.Get("Date"))
+}
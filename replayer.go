@@ -0,0 +1,376 @@
+package vcr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Matcher reports whether an actual request matches a recorded one.
+type Matcher func(r *http.Request, recorded *Request) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(r *http.Request, recorded *Request) bool {
+	return strings.EqualFold(r.Method, recorded.Method)
+}
+
+// MatchURI matches requests for the same URI.
+func MatchURI(r *http.Request, recorded *Request) bool {
+	recordedURI, err := url.Parse(recorded.URI)
+	if err != nil {
+		return false
+	}
+	return r.URL.String() == recordedURI.String()
+}
+
+// MatchHeaders matches requests with exactly the recorded headers. Use IgnoreRequestHeaders to leave out
+// headers that change between runs, such as tracing headers added by middleware.
+func MatchHeaders(r *http.Request, recorded *Request) bool {
+	if len(r.Header) != len(recorded.Headers) {
+		return false
+	}
+	for name, values := range recorded.Headers {
+		if !slices.Equal(r.Header.Values(name), values) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchHeader matches requests with the same values of the named header as the recording, such as
+// Accept-Language for a cassette that holds a localized response for each language at the same URI. A request
+// without the header only matches recordings without it. Use it with MatchOn alongside MatchMethod and MatchURI.
+func MatchHeader(name string) Matcher {
+	return func(r *http.Request, recorded *Request) bool {
+		return slices.Equal(r.Header.Values(name), recorded.Headers.Values(name))
+	}
+}
+
+// MatchJSONSubset matches requests whose JSON body contains every field in template with the same value,
+// allowing clients to send fields the template does not mention. Arrays must match element by element.
+func MatchJSONSubset(template string) Matcher {
+	want, ok := decodeJSON(template)
+	return func(r *http.Request, recorded *Request) bool {
+		if !ok || r.Body == nil {
+			return false
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return false
+		}
+		got, valid := decodeJSON(string(body))
+		return valid && isJSONSubset(want, got)
+	}
+}
+
+// isJSONSubset reports whether every field of want appears in got with the same value.
+func isJSONSubset(want any, got any) bool {
+	switch want := want.(type) {
+	case map[string]any:
+		got, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if actual, ok := got[key]; !ok || !isJSONSubset(value, actual) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		got, ok := got.([]any)
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !isJSONSubset(want[i], got[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return encodeCompact(want) == encodeCompact(got)
+	}
+}
+
+// MatchOn replaces the matchers the Replayer uses to find the interaction for a request.
+// By default requests are matched with MatchMethod and MatchURI.
+func MatchOn(matchers ...Matcher) ReplayOption {
+	return func(c *config) {
+		c.matchers = matchers
+	}
+}
+
+// IgnoreRequestHeaders hides the named headers of both the actual and the recorded request from the
+// Replayer's matchers.
+func IgnoreRequestHeaders(names ...string) ReplayOption {
+	return func(c *config) {
+		c.ignoreReqHeaders = append(c.ignoreReqHeaders, names...)
+	}
+}
+
+// RequireOrder makes the Replayer fail a request that matches an interaction while an earlier
+// interaction in the cassette has not yet been used.
+func RequireOrder() ReplayOption {
+	return func(c *config) {
+		c.requireOrder = true
+	}
+}
+
+// AllowUnmatchedInteractions stops Done failing the test when interactions in the cassette were never requested,
+// for cassettes shared by several tests that each make only some of the requests.
+func AllowUnmatchedInteractions() ReplayOption {
+	return func(c *config) {
+		c.allowUnmatched = true
+	}
+}
+
+// SimulateBackoff makes the Replayer enforce the Retry-After of the 429 and 503 responses it serves: a request for
+// the same method and URI made before the delay has passed, according to now, fails instead of reaching the
+// interaction recorded for the retry. Record each attempt as its own interaction, in order, and give the client
+// under test the same clock to wait on so that its backoff can be checked without sleeping. A nil now uses
+// time.Now.
+func SimulateBackoff(now func() time.Time) ReplayOption {
+	if now == nil {
+		now = time.Now
+	}
+	return func(c *config) {
+		c.backoffClock = now
+	}
+}
+
+// Replayer is an http.RoundTripper that answers requests with the responses recorded in a cassette,
+// so that client code can be tested without a server. Each interaction is used at most once.
+type Replayer struct {
+	cfg  *config
+	tape *Cassette
+
+	mu      sync.Mutex
+	used    []bool
+	retryAt map[string]time.Time
+}
+
+// NewReplayer returns a Replayer serving the interactions in tape.
+func NewReplayer(tape *Cassette, opts ...Option) *Replayer {
+	cfg := newConfig(opts)
+	if cfg.matchers == nil {
+		cfg.matchers = []Matcher{MatchMethod, MatchURI}
+	}
+	return &Replayer{
+		cfg:     cfg,
+		tape:    tape,
+		used:    make([]bool, len(tape.Interactions)),
+		retryAt: map[string]time.Time{},
+	}
+}
+
+// RoundTrip returns the response of the first unused interaction matching r.
+func (p *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := r.Method + " " + r.URL.String()
+	if p.cfg.backoffClock != nil {
+		if retryAt, ok := p.retryAt[key]; ok {
+			if now := p.cfg.backoffClock(); now.Before(retryAt) {
+				return nil, fmt.Errorf("%s %s was retried %s before its Retry-After", r.Method, r.URL, retryAt.Sub(now))
+			}
+			delete(p.retryAt, key)
+		}
+	}
+
+	for i, interaction := range p.tape.Interactions {
+		if p.used[i] || !p.matches(r, body, &interaction.Request) {
+			continue
+		}
+		if p.cfg.requireOrder {
+			for earlier := 0; earlier < i; earlier++ {
+				if !p.used[earlier] {
+					return nil, fmt.Errorf("%s %s matched interaction %d before interaction %d was used", r.Method, r.URL, i, earlier)
+				}
+			}
+		}
+		response := interaction.variant(r.Header.Get("Accept"))
+		if response == nil {
+			return nil, fmt.Errorf("interaction %d has no recorded response", i)
+		}
+		p.used[i] = true
+		if p.cfg.backoffClock != nil && (response.Status.Code == http.StatusTooManyRequests || response.Status.Code == http.StatusServiceUnavailable) {
+			if retryAt, ok := retryAfter(response.Headers.Get("Retry-After"), p.cfg.backoffClock()); ok {
+				p.retryAt[key] = retryAt
+			}
+		}
+		return response.toHTTP(r)
+	}
+
+	return nil, fmt.Errorf("no recorded interaction matches %s %s", r.Method, r.URL)
+}
+
+// Done fails the test if any interaction in the cassette was never requested, which usually means the
+// cassette has drifted from what the client under test does, unless AllowUnmatchedInteractions is set.
+func (p *Replayer) Done(t testing.TB) {
+	t.Helper()
+	if p.cfg.allowUnmatched {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var unused []string
+	for i, interaction := range p.tape.Interactions {
+		if !p.used[i] {
+			unused = append(unused, fmt.Sprintf("interaction %d: %s %s", i, strings.ToUpper(interaction.Request.Method), interaction.Request.URI))
+		}
+	}
+	if len(unused) > 0 {
+		t.Errorf("%d recorded interactions were never requested:\n%s", len(unused), strings.Join(unused, "\n"))
+	}
+}
+
+// matches reports whether every matcher accepts r, giving each a fresh copy of the body.
+func (p *Replayer) matches(r *http.Request, body []byte, recorded *Request) bool {
+	if len(p.cfg.ignoreReqHeaders) > 0 {
+		r = r.Clone(r.Context())
+		copied := *recorded
+		copied.Headers = recorded.Headers.Clone()
+		for _, name := range p.cfg.ignoreReqHeaders {
+			r.Header.Del(name)
+			copied.Headers.Del(name)
+		}
+		recorded = &copied
+	}
+	if p.cfg.canonicalURI {
+		uri, err := url.Parse(canonicalURI(r.URL.String()))
+		if err != nil {
+			return false
+		}
+		r = r.Clone(r.Context())
+		r.URL = uri
+		copied := *recorded
+		copied.URI = canonicalURI(recorded.URI)
+		recorded = &copied
+	}
+	for _, matcher := range p.cfg.matchers {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !matcher(r, recorded) {
+			return false
+		}
+	}
+	return true
+}
+
+// retryAfter returns the time a Retry-After header, either a number of seconds or an HTTP date, asks a client to
+// wait until when it is received at now.
+func retryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// variant returns the response of interaction that accept prefers, trying its media ranges in order of preference
+// against the Content-Type of the interaction's own response and then the media type of each variant. The
+// interaction's own response is returned if none of them is acceptable or for wildcard ranges such as */*.
+func (interaction *Interaction) variant(accept string) *Response {
+	if len(interaction.Variants) == 0 || accept == "" {
+		return interaction.Response
+	}
+	var own string
+	if interaction.Response != nil {
+		own, _, _ = mime.ParseMediaType(interaction.Response.Headers.Get("Content-Type"))
+	}
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if mediaType == own {
+			return interaction.Response
+		}
+		for _, variant := range interaction.Variants {
+			if want, _, err := mime.ParseMediaType(variant.Accept); err == nil && want == mediaType {
+				return variant.Response
+			}
+		}
+	}
+	return interaction.Response
+}
+
+// acceptedMediaTypes returns the media ranges of an Accept header from the most to the least preferred,
+// leaving out those with a quality of zero.
+func acceptedMediaTypes(accept string) []string {
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mediaType
+	}
+	return mediaTypes
+}
+
+// toHTTP builds the response a client receives for resp.
+func (resp *Response) toHTTP(r *http.Request) (*http.Response, error) {
+	body, err := resp.Body.decode()
+	if err != nil {
+		return nil, err
+	}
+	status := http.StatusText(resp.Status.Code)
+	if resp.Status.Message != nil {
+		status = *resp.Status.Message
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status.Code, status),
+		StatusCode:    resp.Status.Code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
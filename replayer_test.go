@@ -0,0 +1,204 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func replayerTape(uris ...string) *vcr.Cassette {
+	tape := &vcr.Cassette{}
+	for _, uri := range uris {
+		interaction := &vcr.Interaction{Response: &vcr.Response{}}
+		interaction.Request.Method = "get"
+		interaction.Request.URI = uri
+		interaction.Response.Status.Code = 200
+		interaction.Response.Body.String = uri
+		tape.Interactions = append(tape.Interactions, interaction)
+	}
+	return tape
+}
+
+func get(t *testing.T, client *http.Client, uri string) (string, error) {
+	t.Helper()
+	resp, err := client.Get(uri)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body), nil
+}
+
+func TestReplayer(t *testing.T) {
+	client := &http.Client{Transport: vcr.NewReplayer(replayerTape("http://localhost/a", "http://localhost/b"))}
+
+	body, err := get(t, client, "http://localhost/b")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/b", body)
+
+	_, err = get(t, client, "http://localhost/b")
+	require.ErrorContains(t, err, "no recorded interaction matches")
+}
+
+func TestReplayerDone(t *testing.T) {
+	replayer := vcr.NewReplayer(replayerTape("http://localhost/a", "http://localhost/b"))
+	client := &http.Client{Transport: replayer}
+
+	_, err := get(t, client, "http://localhost/a")
+	require.NoError(t, err)
+
+	mock := &testing.T{}
+	replayer.Done(mock)
+	require.True(t, mock.Failed())
+
+	_, err = get(t, client, "http://localhost/b")
+	require.NoError(t, err)
+	replayer.Done(t)
+}
+
+func TestAllowUnmatchedInteractions(t *testing.T) {
+	replayer := vcr.NewReplayer(replayerTape("http://localhost/a", "http://localhost/b"), vcr.AllowUnmatchedInteractions())
+	client := &http.Client{Transport: replayer}
+
+	_, err := get(t, client, "http://localhost/a")
+	require.NoError(t, err)
+
+	mock := &testing.T{}
+	replayer.Done(mock)
+	require.False(t, mock.Failed())
+}
+
+func TestReplayerVariants(t *testing.T) {
+	tape := replayerTape("http://localhost/a", "http://localhost/a", "http://localhost/a", "http://localhost/a")
+	for _, interaction := range tape.Interactions {
+		interaction.Response.Headers = http.Header{"Content-Type": {"application/json"}}
+		xml := &vcr.Response{Body: vcr.Body{String: "<a/>"}}
+		xml.Status.Code = 200
+		interaction.Variants = []vcr.Variant{{Accept: "application/xml", Response: xml}}
+	}
+	client := &http.Client{Transport: vcr.NewReplayer(tape)}
+
+	for accept, want := range map[string]string{
+		"application/xml":                         "<a/>",
+		"application/json;q=0.5, application/xml": "<a/>",
+		"application/json, application/xml;q=0.9": "http://localhost/a",
+		"*/*": "http://localhost/a",
+	} {
+		request, err := http.NewRequest(http.MethodGet, "http://localhost/a", nil)
+		require.NoError(t, err)
+		request.Header.Set("Accept", accept)
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, want, string(body), accept)
+	}
+}
+
+func TestSimulateBackoff(t *testing.T) {
+	tape := replayerTape("http://localhost/a", "http://localhost/a")
+	tape.Interactions[0].Response.Status.Code = http.StatusTooManyRequests
+	tape.Interactions[0].Response.Headers = http.Header{"Retry-After": {"2"}}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &http.Client{Transport: vcr.NewReplayer(tape, vcr.SimulateBackoff(func() time.Time {
+		return now
+	}))}
+
+	resp, err := client.Get("http://localhost/a")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	now = now.Add(time.Second)
+	_, err = get(t, client, "http://localhost/a")
+	require.ErrorContains(t, err, "was retried 1s before its Retry-After")
+
+	now = now.Add(time.Second)
+	body, err := get(t, client, "http://localhost/a")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/a", body)
+}
+
+func TestMatchHeader(t *testing.T) {
+	tape := replayerTape("http://localhost/error", "http://localhost/error")
+	for i, language := range []string{"fr", "en"} {
+		tape.Interactions[i].Request.Headers = http.Header{"Accept-Language": {language}}
+		tape.Interactions[i].Response.Body.String = language
+	}
+	client := &http.Client{Transport: vcr.NewReplayer(tape, vcr.MatchOn(vcr.MatchMethod, vcr.MatchURI, vcr.MatchHeader("Accept-Language")))}
+
+	request, err := http.NewRequest(http.MethodGet, "http://localhost/error", nil)
+	require.NoError(t, err)
+	request.Header.Set("Accept-Language", "en")
+	resp, err := client.Do(request)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "en", string(body))
+
+	_, err = get(t, client, "http://localhost/error")
+	require.ErrorContains(t, err, "no recorded interaction matches")
+}
+
+func TestRequireOrder(t *testing.T) {
+	client := &http.Client{Transport: vcr.NewReplayer(replayerTape("http://localhost/a", "http://localhost/b"), vcr.RequireOrder())}
+
+	_, err := get(t, client, "http://localhost/b")
+	require.ErrorContains(t, err, "before interaction 0 was used")
+
+	_, err = get(t, client, "http://localhost/a")
+	require.NoError(t, err)
+	_, err = get(t, client, "http://localhost/b")
+	require.NoError(t, err)
+}
+
+func TestMatchJSONSubset(t *testing.T) {
+	tape := replayerTape("http://localhost/orders")
+	tape.Interactions[0].Request.Method = "post"
+	client := &http.Client{Transport: vcr.NewReplayer(tape, vcr.MatchOn(vcr.MatchMethod, vcr.MatchJSONSubset(`{"item": {"sku": "A1"}}`)))}
+
+	resp, err := client.Post("http://localhost/orders", "application/json", strings.NewReader(`{"item": {"sku": "B2"}}`))
+	require.ErrorContains(t, err, "no recorded interaction matches")
+	require.Nil(t, resp)
+
+	resp, err = client.Post("http://localhost/orders", "application/json", strings.NewReader(`{"item": {"sku": "A1", "gift": true}, "coupon": null}`))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestIgnoreRequestHeaders(t *testing.T) {
+	tape := replayerTape("http://localhost/a")
+	tape.Interactions[0].Request.Headers = http.Header{"Accept": {"text/plain"}}
+
+	request, err := http.NewRequest(http.MethodGet, "http://localhost/a", nil)
+	require.NoError(t, err)
+	request.Header.Set("Accept", "text/plain")
+	request.Header.Set("X-Request-Id", "1234")
+
+	_, err = vcr.NewReplayer(tape, vcr.MatchOn(vcr.MatchMethod, vcr.MatchURI, vcr.MatchHeaders)).RoundTrip(request)
+	require.ErrorContains(t, err, "no recorded interaction matches")
+
+	resp, err := vcr.NewReplayer(tape, vcr.MatchOn(vcr.MatchMethod, vcr.MatchURI, vcr.MatchHeaders), vcr.IgnoreRequestHeaders("X-Request-Id")).RoundTrip(request)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "1234", request.Header.Get("X-Request-Id"))
+}
+
+func TestCanonicalizeURIEncoding(t *testing.T) {
+	tape := replayerTape("http://localhost/files/a%2fb?name=J%c3%b6rg+Smith")
+
+	_, err := get(t, &http.Client{Transport: vcr.NewReplayer(tape)}, "http://localhost/files/a/b?name=J%C3%B6rg%20Smith")
+	require.ErrorContains(t, err, "no recorded interaction matches")
+
+	_, err = get(t, &http.Client{Transport: vcr.NewReplayer(tape, vcr.CanonicalizeURIEncoding())}, "http://localhost/files/a/b?name=J%C3%B6rg%20Smith")
+	require.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package vcr
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// reportEnv names the environment variable holding the path of the JSON report of changed cassettes.
+const reportEnv = "VCR_REPORT"
+
+// ReportEntry describes a cassette that changed during a test run.
+type ReportEntry struct {
+	// Cassette is the path of the cassette, as passed to Replay.
+	Cassette string `json:"cassette"`
+	// Test is the name of the test that replayed the cassette.
+	Test string `json:"test"`
+	// Changed is the number of interactions that differed from the recording.
+	Changed int `json:"changed"`
+}
+
+var report struct {
+	sync.Mutex
+	entries []ReportEntry
+}
+
+// addToReport notes that changed interactions in the cassette at path differed from the recording and,
+// when VCR_REPORT is set, rewrites the report so that it is complete whenever the test binary exits.
+func addToReport(t *testing.T, path string, changed int) {
+	t.Helper()
+
+	reportPath := os.Getenv(reportEnv)
+	if reportPath == "" || changed == 0 {
+		return
+	}
+
+	report.Lock()
+	defer report.Unlock()
+
+	report.entries = append(report.entries, ReportEntry{Cassette: path, Test: t.Name(), Changed: changed})
+	sort.SliceStable(report.entries, func(i, j int) bool {
+		return report.entries[i].Cassette < report.entries[j].Cassette
+	})
+
+	encoded, err := json.MarshalIndent(report.entries, "", "  ")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(reportPath, append(encoded, '\n'), 0o644))
+}
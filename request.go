@@ -0,0 +1,210 @@
+package vcr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// CanonicalizeURIEncoding rewrites request URIs with canonical percent-encoding, decoding what need not be
+// escaped and escaping the rest with upper case hex, so that clients encoding the same URI differently do not
+// cause churn. It applies to the requests sent to the handler, to the URIs a Replayer matches and to those
+// recorded by ReplayServer and Recorder.
+func CanonicalizeURIEncoding() ReplayOption {
+	return func(c *config) {
+		c.canonicalURI = true
+	}
+}
+
+// canonicalURI returns uri with canonical percent-encoding in its path and query, or uri itself if it cannot
+// be parsed.
+func canonicalURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		parts := strings.Split(u.RawQuery, "&")
+		for i, part := range parts {
+			key, value, found := strings.Cut(part, "=")
+			part = canonicalQueryComponent(key)
+			if found {
+				part += "=" + canonicalQueryComponent(value)
+			}
+			parts[i] = part
+		}
+		u.RawQuery = strings.Join(parts, "&")
+	}
+	return u.String()
+}
+
+// canonicalQueryComponent re-escapes a key or value of a query, leaving it as it is if it is not validly escaped.
+func canonicalQueryComponent(s string) string {
+	unescaped, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return url.QueryEscape(unescaped)
+}
+
+// newRequest builds the request a handler receives for a recorded interaction.
+func newRequest(interaction *Interaction, cfg *config) (*http.Request, error) {
+	uri := interaction.Request.URI
+	if cfg.canonicalURI {
+		uri = canonicalURI(uri)
+	}
+	requestURI, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Request.Path != "" {
+		path, err := url.Parse(interaction.Request.Path)
+		if err != nil {
+			return nil, err
+		}
+		requestURI = requestURI.ResolveReference(path)
+	}
+	if cfg.rewriteURI != nil {
+		requestURI = cfg.rewriteURI(requestURI)
+	}
+	if len(cfg.queryParams) > 0 {
+		query := requestURI.Query()
+		for key, values := range cfg.queryParams {
+			query[key] = append(query[key], values...)
+		}
+		requestURI.RawQuery = query.Encode()
+	}
+
+	// copy the headers so that neither we nor the handler modify the cassette
+	header := interaction.Request.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	var requestBody io.ReadCloser = http.NoBody
+	var contentLength int64
+	if decoded, ok, err := interaction.Request.body(); err != nil {
+		return nil, err
+	} else if ok {
+		requestBody = io.NopCloser(strings.NewReader(decoded))
+		contentLength = int64(len(decoded))
+
+		if header.Get("Content-Type") == "" {
+			if contentType := detectContentType(decoded); contentType != "" {
+				header.Set("Content-Type", contentType)
+			}
+		}
+	}
+
+	request := &http.Request{
+		Method:     strings.ToUpper(interaction.Request.Method),
+		URL:        requestURI,
+		Proto:      fmt.Sprintf("HTTP/%d.%d", cfg.protoMajor, cfg.protoMinor),
+		ProtoMajor: cfg.protoMajor,
+		ProtoMinor: cfg.protoMinor,
+		Body:       requestBody,
+		Header:     header,
+		RemoteAddr: cfg.remoteAddr,
+		TLS:        cfg.tls,
+		// fill in what a server would, for middleware that relies on it
+		ContentLength: contentLength,
+		Host:          requestURI.Host,
+		RequestURI:    requestURI.RequestURI(),
+	}
+	names := make([]string, 0, len(interaction.Request.Cookies))
+	for name := range interaction.Request.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		request.AddCookie(&http.Cookie{Name: name, Value: interaction.Request.Cookies[name]})
+	}
+	for _, cookie := range cfg.cookies {
+		request.AddCookie(cookie)
+	}
+	if cfg.requestIDHeader != "" {
+		request.Header.Set(cfg.requestIDHeader, correlatedRequestID)
+	}
+	if interaction.Request.RemoteAddr != "" {
+		request.RemoteAddr = interaction.Request.RemoteAddr
+	}
+	if len(interaction.Request.Trailers) > 0 {
+		// like a server, announce the trailers up front and only fill them in once the body has been read
+		request.Trailer = http.Header{}
+		for name := range interaction.Request.Trailers {
+			request.Trailer[http.CanonicalHeaderKey(name)] = nil
+		}
+		request.Body = &trailerReader{ReadCloser: request.Body, trailer: request.Trailer, values: interaction.Request.Trailers}
+		request.TransferEncoding = []string{"chunked"}
+		request.ContentLength = -1
+	}
+	return request, nil
+}
+
+// body returns the body sent for r and whether it has one. A recorded body is used verbatim, but must agree with
+// the form if there is one too, and a form on its own is encoded.
+func (r *Request) body() (string, bool, error) {
+	if r.Body == nil {
+		if len(r.Form) == 0 {
+			return "", false, nil
+		}
+		return r.Form.Encode(), true, nil
+	}
+	decoded, err := r.Body.decode()
+	if err != nil {
+		return "", false, err
+	}
+	if len(r.Form) > 0 {
+		if parsed, err := url.ParseQuery(decoded); err != nil || !reflect.DeepEqual(parsed, r.Form) {
+			return "", false, fmt.Errorf("body %q does not match form %q", decoded, r.Form.Encode())
+		}
+	}
+	return decoded, true, nil
+}
+
+// trailerReader sets the values of a request's trailers when its body has been read to the end.
+type trailerReader struct {
+	io.ReadCloser
+	trailer http.Header
+	values  http.Header
+}
+
+func (r *trailerReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		for name, values := range r.values {
+			r.trailer[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	return n, err
+}
+
+// recordedCookies returns the cookies sent with r, or nil if there are none.
+func recordedCookies(r *http.Request) map[string]string {
+	var cookies map[string]string
+	for _, cookie := range r.Cookies() {
+		if cookies == nil {
+			cookies = map[string]string{}
+		}
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
+}
+
+// detectContentType guesses the Content-Type of a recorded request body that does not declare one.
+// Only JSON and form bodies are recognised; anything else is left for the handler to sniff.
+func detectContentType(body string) string {
+	if looksLikeJSON(body) {
+		return "application/json"
+	}
+	if values, err := url.ParseQuery(body); err == nil && len(values) > 0 && strings.Contains(body, "=") {
+		return "application/x-www-form-urlencoded"
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package vcr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ValidateSchema checks the JSON body returned for every request whose path matches pattern, using the
+// syntax of path.Match, against a JSON Schema. Values may change between runs but the shape must hold.
+//
+// The type, enum, const, properties, required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum and maximum keywords are supported; others are ignored.
+func ValidateSchema(pattern string, schema []byte) ReplayOption {
+	return func(c *config) {
+		c.schemas = append(c.schemas, schemaCheck{pattern: pattern, schema: schema})
+	}
+}
+
+type schemaCheck struct {
+	pattern string
+	schema  []byte
+}
+
+// checkSchemas validates recording against the schemas whose pattern matches r.
+func checkSchemas(t *testing.T, i int, r *http.Request, recording *Response, checks []schemaCheck) {
+	t.Helper()
+	for _, check := range checks {
+		matched, err := path.Match(check.pattern, r.URL.Path)
+		require.NoError(t, err)
+		if !matched {
+			continue
+		}
+
+		schema, ok := decodeJSON(string(check.schema))
+		require.Truef(t, ok, "schema for %s is not valid JSON", check.pattern)
+
+		body, err := recording.Body.decode()
+		require.NoError(t, err)
+		doc, ok := decodeJSON(body)
+		require.Truef(t, ok, "interaction %d: %s %s did not return a JSON body", i, r.Method, r.URL.Path)
+
+		errs := validateSchema(schema, doc, "$")
+		require.Emptyf(t, errs, "interaction %d: %s %s does not match its schema:\n%s", i, r.Method, r.URL.Path, strings.Join(errs, "\n"))
+	}
+}
+
+// validateSchema returns a description of every way doc, found at location, fails to satisfy schema.
+func validateSchema(schema any, doc any, location string) []string {
+	rules, ok := schema.(map[string]any)
+	if !ok {
+		// true, or anything we do not understand, accepts everything
+		if accept, ok := schema.(bool); ok && !accept {
+			return []string{fmt.Sprintf("%s is not allowed", location)}
+		}
+		return nil
+	}
+
+	var errs []string
+	fail := func(format string, args ...any) {
+		errs = append(errs, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if expected, ok := rules["type"]; ok && !matchesType(expected, doc) {
+		fail("expected type %v, got %s", expected, jsonType(doc))
+		return errs
+	}
+	if values, ok := rules["enum"].([]any); ok && !containsJSON(values, doc) {
+		fail("%s is not one of %s", encodeCompact(doc), encodeCompact(values))
+	}
+	if value, ok := rules["const"]; ok && !containsJSON([]any{value}, doc) {
+		fail("%s is not %s", encodeCompact(doc), encodeCompact(value))
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		properties, _ := rules["properties"].(map[string]any)
+		if required, ok := rules["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := node[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key]; ok {
+				errs = append(errs, validateSchema(property, node[key], location+"."+key)...)
+			} else if additional, ok := rules["additionalProperties"]; ok {
+				errs = append(errs, validateSchema(additional, node[key], location+"."+key)...)
+			}
+		}
+	case []any:
+		if min, ok := schemaInt(rules["minItems"]); ok && len(node) < min {
+			fail("expected at least %d items, got %d", min, len(node))
+		}
+		if max, ok := schemaInt(rules["maxItems"]); ok && len(node) > max {
+			fail("expected at most %d items, got %d", max, len(node))
+		}
+		if items, ok := rules["items"]; ok {
+			for i, item := range node {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", location, i))...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(node)
+		if min, ok := schemaInt(rules["minLength"]); ok && length < min {
+			fail("expected at least %d characters, got %d", min, length)
+		}
+		if max, ok := schemaInt(rules["maxLength"]); ok && length > max {
+			fail("expected at most %d characters, got %d", max, length)
+		}
+		if expr, ok := rules["pattern"].(string); ok {
+			if re, err := regexp.Compile(expr); err != nil {
+				fail("invalid pattern %q: %v", expr, err)
+			} else if !re.MatchString(node) {
+				fail("%q does not match %q", node, expr)
+			}
+		}
+	case json.Number:
+		value, _ := new(big.Float).SetString(node.String())
+		if min, ok := rules["minimum"].(json.Number); ok {
+			if bound, _ := new(big.Float).SetString(min.String()); value != nil && bound != nil && value.Cmp(bound) < 0 {
+				fail("%s is less than the minimum %s", node, min)
+			}
+		}
+		if max, ok := rules["maximum"].(json.Number); ok {
+			if bound, _ := new(big.Float).SetString(max.String()); value != nil && bound != nil && value.Cmp(bound) > 0 {
+				fail("%s is greater than the maximum %s", node, max)
+			}
+		}
+	}
+	return errs
+}
+
+// jsonType names the JSON Schema type of a decoded value.
+func jsonType(doc any) string {
+	switch node := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		// JSON Schema counts any number with a zero fractional part as an integer, however it is written
+		if value, ok := new(big.Float).SetString(node.String()); ok && value.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// matchesType reports whether doc is of the type, or one of the types, named by expected.
+func matchesType(expected any, doc any) bool {
+	actual := jsonType(doc)
+	names, ok := expected.([]any)
+	if !ok {
+		names = []any{expected}
+	}
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsJSON reports whether values contains a value equal to doc.
+func containsJSON(values []any, doc any) bool {
+	encoded := encodeCompact(doc)
+	for _, value := range values {
+		if encodeCompact(value) == encoded {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeCompact encodes a decoded value on a single line, with sorted keys.
+func encodeCompact(doc any) string {
+	encoded, _ := json.Marshal(doc)
+	return string(encoded)
+}
+
+// schemaInt reads a non-negative integer keyword.
+func schemaInt(value any) (int, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	n, err := number.Int64()
+	return int(n), err == nil
+}
@@ -0,0 +1,39 @@
+package vcr
+
+import (
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterNormalizer("text/event-stream", NormalizeSSE())
+}
+
+// NormalizeSSE rewrites a Server-Sent Events body with one line per field and a blank line between events,
+// whatever line endings or chunking the handler used. The id and retry fields and comments are dropped and
+// timestamps in the remaining fields are replaced, as they usually change between runs.
+func NormalizeSSE() NormalizeOption {
+	return func(resp *Response) {
+		body, err := resp.Body.decode()
+		if err != nil {
+			return
+		}
+		body = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(body)
+
+		var events []string
+		for _, event := range strings.Split(body, "\n\n") {
+			var fields []string
+			for _, line := range strings.Split(event, "\n") {
+				name, _, _ := strings.Cut(line, ":")
+				if line == "" || name == "" || name == "id" || name == "retry" {
+					continue
+				}
+				fields = append(fields, timestampPattern.ReplaceAllLiteralString(line, time.Time{}.Format(time.RFC3339Nano)))
+			}
+			if len(fields) > 0 {
+				events = append(events, strings.Join(fields, "\n"))
+			}
+		}
+		resp.Body = newBody(strings.Join(events, "\n\n"))
+	}
+}
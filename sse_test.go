@@ -0,0 +1,21 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeSSE(t *testing.T) {
+	event := func(body string) *vcr.Response {
+		resp := &vcr.Response{Headers: http.Header{"Content-Type": {"text/event-stream"}}}
+		resp.Body.String = body
+		return vcr.Normalize(resp)
+	}
+
+	a := event("id: 1\r\nretry: 3000\r\ndata: {\"at\": \"2023-04-09T13:05:58Z\"}\r\n\r\n: heartbeat\r\n\r\nevent: done\r\ndata: ok\r\n\r\n")
+	b := event("id: 2\ndata: {\"at\": \"2024-01-01T00:00:00Z\"}\n\nevent: done\ndata: ok\n\n")
+	require.Equal(t, a.Body.String, b.Body.String)
+	require.Equal(t, "data: {\"at\": \"0001-01-01T00:00:00Z\"}\n\nevent: done\ndata: ok", a.Body.String)
+}
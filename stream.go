@@ -0,0 +1,107 @@
+package vcr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// Streaming replays a cassette stored as a stream of YAML documents, one interaction per document. Each
+// interaction is decoded, replayed, compared and, with -overwrite, written out before the next is read, so
+// cassettes far larger than memory can be replayed. WriteStream converts a Cassette into this form.
+func Streaming() ReplayOption {
+	return func(c *config) {
+		c.streaming = true
+	}
+}
+
+// WriteStream writes the interactions in c to w as a stream of YAML documents, as read by Streaming.
+// RecordedWith is not part of the stream.
+func WriteStream(w io.Writer, c *Cassette) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	for _, interaction := range c.Interactions {
+		if err := encoder.Encode(interaction); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+// streamTape replays the streamed cassette at path one interaction at a time, writing back the responses of the
+// handler when overwrite is set.
+func streamTape(t *testing.T, path string, handler http.Handler, cfg *config, overwrite bool) {
+	t.Helper()
+
+	fd, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, fd.Close())
+	}()
+
+	decoder := yaml.NewDecoder(fd)
+	decoder.KnownFields(true)
+
+	var tmp *os.File
+	var encoder *yaml.Encoder
+	if overwrite {
+		tmp = createTemp(t, fd.Name(), cfg)
+		defer tmp.Close()
+		encoder = yaml.NewEncoder(tmp)
+		encoder.SetIndent(2)
+	}
+
+	dir := filepath.Dir(path)
+	isModified := newComparison(cfg)
+	only := onlyIndex(t)
+	changed := 0
+
+	for i := 0; ; i++ {
+		var interaction Interaction
+		err := decoder.Decode(&interaction)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		single := &Cassette{Interactions: []*Interaction{&interaction}}
+		require.NoError(t, readBodies(dir, single))
+
+		var before, after bytes.Buffer
+		if encoder == nil {
+			require.NoError(t, yaml.NewEncoder(&before).Encode(&interaction))
+		}
+
+		if (only < 0 || i == only) && isSelected(&interaction, cfg) && replayInteraction(t, i, handler, &interaction, cfg, isModified) {
+			changed++
+		}
+
+		if encoder != nil {
+			require.NoError(t, writeBodies(dir, single, cfg))
+			require.NoError(t, encoder.Encode(&interaction))
+			continue
+		}
+
+		require.NoError(t, yaml.NewEncoder(&after).Encode(&interaction))
+		if before.String() != after.String() {
+			addToReport(t, path, changed)
+		}
+		require.Equalf(t, before.String(), after.String(), "interaction %d has changed. run this test with the -overwrite flag and commit the result if this change looks legitimate", i)
+	}
+
+	if encoder == nil {
+		return
+	}
+
+	addToReport(t, path, changed)
+
+	require.NoError(t, encoder.Close())
+	require.NoError(t, tmp.Close())
+	require.NoError(t, os.Rename(tmp.Name(), fd.Name()))
+}
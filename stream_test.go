@@ -0,0 +1,42 @@
+package vcr_test
+
+import (
+	"bytes"
+	"flag"
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreaming(t *testing.T) {
+	tape, err := vcr.Load("vcr_test.yml")
+	require.NoError(t, err)
+
+	var stream bytes.Buffer
+	require.NoError(t, vcr.WriteStream(&stream, tape))
+	path := filepath.Join(t.TempDir(), "stream.yml")
+	require.NoError(t, os.WriteFile(path, stream.Bytes(), 0o644))
+
+	greeting := "Hello world!"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, greeting, 200)
+	})
+	vcr.Replay(t, path, mux, vcr.Streaming())
+
+	greeting = "Goodbye world!"
+	require.NoError(t, flag.Set("overwrite", "true"))
+	defer func() {
+		require.NoError(t, flag.Set("overwrite", "false"))
+	}()
+	vcr.Replay(t, path, mux, vcr.Streaming())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(contents), "# generated by stream_test.go\n"))
+	require.Contains(t, string(contents), "Goodbye world!")
+}
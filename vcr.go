@@ -1,78 +1,41 @@
 // Package vcr contains a function for recording VCR cassettes.
+//
+// Replay may be called from parallel tests. Calls that share a cassette file take turns, so the handler
+// is the only state that needs to be safe for concurrent use. Package variables such as
+// MaxTestSearchDepth must only be changed before any tests run.
 package vcr
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
-
-	"gopkg.in/yaml.v3"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
-type Response struct {
-	Status struct {
-		Code    int     `yaml:"code"`
-		Message *string `yaml:"message"`
-	} `yaml:"status"`
-	Headers http.Header `yaml:"headers"`
-	Body    struct {
-		Encoding string `yaml:"encoding"`
-		String   string `yaml:"string"`
-	} `yaml:"body"`
-	HttpVersion any `yaml:"http_version"`
-}
-
-type cassette struct {
-	Interactions []*struct {
-		Request struct {
-			Method string `yaml:"method"`
-			URI    string `yaml:"uri"`
-			Body   *struct {
-				Encoding string `yaml:"encoding"`
-				String   string `yaml:"string"`
-			} `yaml:"body,omitempty"`
-			Headers http.Header `yaml:"headers"`
-			Form    url.Values  `yaml:"form,omitempty"`
-		} `yaml:"request"`
-		Response   *Response `yaml:"response"`
-		RecordedAt string    `yaml:"recorded_at"`
-	} `yaml:"http_interactions"`
-	RecordedWith string `yaml:"recorded_with"`
-}
-
-func open(r io.Reader) (*cassette, error) {
-	decoder := yaml.NewDecoder(r)
-	decoder.KnownFields(true)
-
-	var tape cassette
-	if err := decoder.Decode(&tape); err != nil {
-		return nil, err
-	}
-	return &tape, nil
-}
-
-func encode(w io.Writer, c *cassette) error {
-	encoder := yaml.NewEncoder(w)
-	encoder.SetIndent(2)
-	return encoder.Encode(c)
-}
-
 func normalizeJson(input string) string {
 	var decoded interface{}
 	if err := json.Unmarshal([]byte(input), &decoded); err == nil {
@@ -83,76 +46,673 @@ func normalizeJson(input string) string {
 	return input
 }
 
-// replay a VCR and check for updates
-func replay(t *testing.T, handler http.Handler, tape *cassette, opts []NormalizeOption) {
+// replay a VCR and check for updates, returning the number of interactions that changed
+func replay(t *testing.T, handler http.Handler, tape *Cassette, cfg *config) (changed int) {
 	t.Helper()
-	for _, interaction := range tape.Interactions {
-		requestURI, err := url.Parse(interaction.Request.URI)
-		require.NoError(t, err)
+	cfg = withCassetteOptions(cfg, tape)
+	if cfg.recordedWith != "" {
+		checkRecordedWith(t, tape, cfg.recordedWith)
+	}
+	isModified := newComparison(cfg)
+	only := onlyIndex(t)
+	for i, interaction := range tape.Interactions {
+		if (only >= 0 && i != only) || !isSelected(interaction, cfg) {
+			continue
+		}
+		if replayInteraction(t, i, handler, interaction, cfg, isModified) {
+			changed++
+		}
+		if len(interaction.Request.Variants) > 0 {
+			checkRequestVariants(t, i, handler, interaction, cfg, isModified)
+		}
+	}
+	return changed
+}
 
-		recorder := httptest.NewRecorder()
+// recordedAtFormats are the layouts accepted for recorded_at, which is always written as http.TimeFormat.
+// Cassettes imported from other tools or edited by hand often use RFC 3339.
+var recordedAtFormats = []string{http.TimeFormat, time.RFC1123Z, time.RFC1123, time.RFC3339Nano}
 
-		var requestBody io.ReadCloser
-		if interaction.Request.Body != nil {
-			requestBody = io.NopCloser(strings.NewReader(interaction.Request.Body.String))
+// parseRecordedAt parses a recorded_at timestamp in any of the accepted formats.
+func parseRecordedAt(value string) (time.Time, error) {
+	var err error
+	for _, layout := range recordedAtFormats {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, value); err == nil {
+			return parsed, nil
 		}
+	}
+	return time.Time{}, fmt.Errorf("recorded_at %q is not a valid timestamp: %w", value, err)
+}
+
+// withCassetteOptions returns cfg with the normalization declared by tape applied before the options passed in code.
+func withCassetteOptions(cfg *config, tape *Cassette) *config {
+	opts := tape.Normalize.options()
+	if len(opts) == 0 {
+		return cfg
+	}
+	merged := *cfg
+	merged.normalizers = append(opts, cfg.normalizers...)
+	return &merged
+}
+
+// onlyIndexEnv names the environment variable that restricts replay to the interaction at a single index.
+const onlyIndexEnv = "VCR_ONLY_INDEX"
 
-		request := &http.Request{
-			Method: strings.ToUpper(interaction.Request.Method),
-			URL:    requestURI,
-			Body:   requestBody,
-			Header: interaction.Request.Headers,
+// onlyIndex returns the index of the only interaction to replay, or -1 to replay them all.
+func onlyIndex(t *testing.T) int {
+	t.Helper()
+	value := os.Getenv(onlyIndexEnv)
+	if value == "" {
+		return -1
+	}
+	index, err := strconv.Atoi(value)
+	require.NoErrorf(t, err, "%s must be an interaction index", onlyIndexEnv)
+	return index
+}
+
+// isSelected reports whether interaction passes the tag filter set with WithTags.
+func isSelected(interaction *Interaction, cfg *config) bool {
+	for _, tag := range interaction.Tags {
+		if slices.Contains(cfg.excludeTags, tag) {
+			return false
+		}
+	}
+	if len(cfg.includeTags) == 0 || len(interaction.Tags) == 0 {
+		return true
+	}
+	for _, tag := range interaction.Tags {
+		if slices.Contains(cfg.includeTags, tag) {
+			return true
 		}
+	}
+	return false
+}
 
-		handler.ServeHTTP(recorder, request)
+// replayInteraction serves the i-th interaction of a cassette with handler and replaces its response if
+// it has been modified, reporting whether it was.
+func replayInteraction(t *testing.T, i int, handler http.Handler, interaction *Interaction, cfg *config, isModified func(before *Response, after *Response) bool) bool {
+	t.Helper()
 
-		response := recorder.Result()
+	if cfg.freshHandler != nil {
+		handler = withMiddleware(cfg.freshHandler(), cfg)
+	}
+
+	if cfg.regenerate && overwriting() {
+		// forget the recording so that the response is recorded afresh
+		interaction.Response = nil
+		interaction.RecordedAt = ""
+	}
+
+	request, err := newRequest(interaction, cfg)
+	require.NoError(t, err)
+	request = withInteraction(request, i, interaction)
+	request, cancel := withDeadline(request, cfg)
+	defer cancel()
+	requestURI := request.URL
 
-		if interaction.Response != nil && interaction.Response.Status.Code != response.StatusCode {
-			body, _ := io.ReadAll(response.Body)
-			_ = response.Body.Close()
-			require.Equalf(t, interaction.Response.Status.Code, response.StatusCode, "response for %v does not match recording: %s", requestURI.Path, string(body))
+	recorder := httptest.NewRecorder()
+
+	var w http.ResponseWriter = recorder
+	var flusher *flushRecorder
+	if cfg.recordFlushes {
+		flusher = &flushRecorder{ResponseRecorder: recorder}
+		w = flusher
+	}
+
+	var orderer *orderRecorder
+	if cfg.orderedHeaders {
+		orderer = &orderRecorder{ResponseWriter: w, seen: map[string]bool{}}
+		w = orderer
+	}
+
+	informational := &informationalRecorder{ResponseWriter: w}
+	w = informational
+
+	var hijacker *hijackRecorder
+	if cfg.recordHijacked {
+		hijacker = &hijackRecorder{ResponseWriter: w}
+		w = hijacker
+	}
+
+	if cfg.delays && interaction.DelayMs > 0 {
+		time.Sleep(time.Duration(interaction.DelayMs) * time.Millisecond)
+	}
+
+	// keep hold of the body in case the handler replaces it
+	body := request.Body
+
+	if cfg.captureLog != nil {
+		cfg.captureLog.Reset()
+	}
+
+	serveInteraction(t, i, handler, w, request, cfg.timeout)
+
+	var log string
+	if cfg.captureLog != nil {
+		log = cfg.captureLog.String()
+	}
+
+	if cfg.bodyConsumed {
+		checkBodyConsumed(t, i, request, body)
+	}
+
+	if cfg.statusOnly {
+		if interaction.Response != nil {
+			expected, actual := interaction.Response.Status.Code, recorder.Code
+			require.Equalf(t, expected, actual, "interaction %d: %s %v returned %d %s but the recording expects %d %s", i, request.Method, requestURI.Path, actual, http.StatusText(actual), expected, http.StatusText(expected))
 		}
+		return false
+	}
 
-		// we do not need the response body, however it must be closed to avoid resource leaks
-		_ = response.Body.Close()
+	if cfg.checkContentLength {
+		checkContentLength(t, i, request, recorder)
+	}
 
-		body := recorder.Body.String()
+	if cfg.duplicateKeys {
+		checkDuplicateKeys(t, i, request, recorder)
+	}
 
-		var contentType string
-		if response.Header != nil {
-			contentType = response.Header.Get("Content-Type")
+	recording := newRecording(request, recorder, cfg)
+	if flusher != nil {
+		recording.Chunks = flusher.Chunks()
+	}
+	if orderer != nil {
+		recording.HeaderOrder = orderer.Order()
+	}
+	if cfg.capture1xx {
+		recording.Informational = informational.responses
+	}
+	if hijacker != nil && hijacker.conn != nil {
+		recording.Body = Body{Encoding: encodingBase64, String: base64.StdEncoding.EncodeToString(hijacker.conn.Bytes())}
+		recording.Headers.Del("Content-Length")
+		recording.Hijacked = true
+	}
+
+	if cfg.idempotent {
+		checkIdempotent(t, i, handler, interaction, recording, cfg)
+	}
+
+	if interaction.Response != nil && interaction.Response.Status.Code != recording.Status.Code {
+		expected, actual := interaction.Response.Status.Code, recording.Status.Code
+		require.Equalf(t, expected, actual, "interaction %d: %s %v returned %d %s but the recording expects %d %s\nactual body:\n%s\nrecorded body:\n%s", i, request.Method, requestURI.Path, actual, http.StatusText(actual), expected, http.StatusText(expected), describeBody(recording, cfg), describeBody(interaction.Response, cfg))
+	}
+
+	if cfg.contentCategory && interaction.Response != nil {
+		checkContentCategory(t, i, request, interaction.Response, recording)
+	}
+
+	if len(cfg.requiredHeaders) > 0 {
+		checkHeadersPresent(t, i, request, recording, cfg.requiredHeaders)
+	}
+
+	if cfg.warnContentType {
+		checkContentType(t, i, request, recording)
+	}
+
+	if cfg.limitBodySize {
+		checkBodySize(t, i, request, recording, cfg)
+	}
+
+	if len(cfg.schemas) > 0 {
+		checkSchemas(t, i, request, recording, cfg.schemas)
+	}
+
+	recorded := interaction.Response
+	if len(interaction.AssertHeaders) > 0 {
+		checkAssertedHeaders(t, i, request, interaction.AssertHeaders, recording)
+		recording.Headers = onlyHeaders(recording.Headers, interaction.AssertHeaders)
+		if recorded != nil {
+			recorded = &Response{}
+			*recorded = *interaction.Response
+			recorded.Headers = onlyHeaders(recorded.Headers, interaction.AssertHeaders)
 		}
-		if contentType == "application/json" {
-			// protobuf randomly inserts spaces and so you cannot reliably compare json strings
-			// re-encode using the standard library
-			body = normalizeJson(body)
+	}
+
+	if cfg.terminalRedirects && !overwriting() {
+		checkRedirect(t, i, request, interaction.Response, recording)
+	}
+
+	if cfg.noNewHeaders && !overwriting() {
+		checkNewHeaders(t, i, request, interaction.Response, recording)
+	}
+
+	if interaction.RecordedAt != "" {
+		// check that the recorded at is valid
+		recordedAt, err := parseRecordedAt(interaction.RecordedAt)
+		require.NoErrorf(t, err, "interaction %d", i)
+		if overwriting() {
+			interaction.RecordedAt = recordedAt.UTC().Format(http.TimeFormat)
 		}
+	}
 
-		recording := &Response{}
-		recording.Status.Code = recorder.Code
-		recording.Body.Encoding = "UTF-8"
-		recording.Body.String = body
-		recording.Headers = response.Header
-		recording.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	var modified bool
+	if cfg.comparisonOnly {
+		modified = checkComparisonOnly(t, i, request, recorded, recording, isModified)
+	} else {
+		modified = isModified(recorded, recording)
+	}
 
-		if interaction.RecordedAt != "" {
-			// check that the recorded at is valid
-			_, err = time.Parse(http.TimeFormat, interaction.RecordedAt)
-			require.NoError(t, err)
+	// reduce the noise in diffs by only updating the timestamp of things
+	// that have changed
+	if !modified {
+		return false
+	}
+	interaction.Response = recording
+	interaction.RecordedAt = time.Now().UTC().Format(http.TimeFormat)
+	if cfg.captureLog != nil && overwriting() {
+		interaction.Log = log
+	}
+	if cfg.recordedBy {
+		interaction.RecordedBy = testPath(t, cfg)
+	}
+	return true
+}
+
+// describeBody returns the body of resp as it is compared, with JSON indented, for use in failure messages.
+func describeBody(resp *Response, cfg *config) string {
+	resp = comparable(resp, cfg, nil)
+	body, err := resp.Body.decode()
+	if err != nil {
+		return resp.Body.String
+	}
+	if isJSON(resp.Headers) {
+		body = normalizeJson(body)
+	}
+	return body
+}
+
+// InteractionInfo describes the interaction of a cassette that a request is being replayed for.
+type InteractionInfo struct {
+	// Index is the position of the interaction in the cassette.
+	Index int
+	// Tags are the tags of the interaction.
+	Tags []string
+}
+
+type interactionKey struct{}
+
+// InteractionFromContext returns the interaction that the request with ctx is replaying, so that a handler can
+// log or assert on which recorded scenario it is serving. It reports false outside of a replay.
+func InteractionFromContext(ctx context.Context) (InteractionInfo, bool) {
+	info, ok := ctx.Value(interactionKey{}).(InteractionInfo)
+	return info, ok
+}
+
+// withInteraction gives request a context describing the i-th interaction, for InteractionFromContext.
+func withInteraction(request *http.Request, i int, interaction *Interaction) *http.Request {
+	info := InteractionInfo{Index: i, Tags: interaction.Tags}
+	return request.WithContext(context.WithValue(request.Context(), interactionKey{}, info))
+}
+
+// withDeadline gives request a context with the deadline set by WithDeadline, if any.
+func withDeadline(request *http.Request, cfg *config) (*http.Request, context.CancelFunc) {
+	if cfg.deadline <= 0 {
+		return request, func() {}
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), cfg.deadline)
+	return request.WithContext(ctx), cancel
+}
+
+// serveInteraction calls handler, turning a panic into a test failure that identifies the interaction.
+// When timeout is set the handler runs in its own goroutine and the test fails if it has not returned in time,
+// once the cancelled handler has stopped writing to w.
+func serveInteraction(t *testing.T, i int, handler http.Handler, w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	t.Helper()
+
+	if timeout <= 0 {
+		defer func() {
+			if err := recover(); err != nil {
+				failHijacked(t, i, r, err)
+				require.FailNowf(t, "handler panicked", "interaction %d (%s %s) panicked: %v\n%s", i, r.Method, r.URL, err, debug.Stack())
+			}
+		}()
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	// cancel the request once we give up on it so that a well-behaved handler can exit
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	done := make(chan struct{})
+	var panicked any
+	var stack []byte
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				panicked, stack = err, debug.Stack()
+			}
+		}()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		if panicked != nil {
+			failHijacked(t, i, r, panicked)
+			require.FailNowf(t, "handler panicked", "interaction %d (%s %s) panicked: %v\n%s", i, r.Method, r.URL, panicked, stack)
+		}
+	case <-timer.C:
+		cancel()
+		<-done
+		require.FailNowf(t, "handler timed out", "interaction %d (%s %s) did not complete within %s", i, r.Method, r.URL, timeout)
+	}
+}
+
+// failHijacked fails with an explanation if a handler panicked because the writer it was given cannot hijack the
+// connection, usually from an unchecked type assertion to http.Hijacker.
+func failHijacked(t *testing.T, i int, r *http.Request, panicked any) {
+	t.Helper()
+	if err, ok := panicked.(*runtime.TypeAssertionError); ok && strings.Contains(err.Error(), "http.Hijacker") {
+		require.FailNowf(t, "handler hijacked the connection", "interaction %d (%s %s) tried to hijack the connection, replay with RecordHijacked to record what it writes: %v", i, r.Method, r.URL, err)
+	}
+}
+
+// newRecording converts the result captured by recorder into a Response as it is stored in a cassette
+func newRecording(r *http.Request, recorder *httptest.ResponseRecorder, cfg *config) *Response {
+	response := recorder.Result()
+
+	// we do not need the response body, however it must be closed to avoid resource leaks
+	_ = response.Body.Close()
+
+	body := recorder.Body.String()
+
+	if isCanonicalJSON(response.Header, body, cfg) && !isRawJSON(r, cfg) && !cfg.wireJSON {
+		// protobuf randomly inserts spaces and so you cannot reliably compare json strings
+		// re-encode using the standard library
+		body = normalizeJson(body)
+	}
+
+	length := len(body)
+	if cfg.truncateBodies && len(body) > cfg.truncateAt {
+		body = truncateBody(body, cfg.truncateAt)
+	}
+
+	recording := &Response{}
+	recording.Status.Code = recorder.Code
+	recording.Body = newBody(body)
+	if cfg.hashBody && !isText(response.Header) {
+		recording.Body = newHashedBody(body)
+	}
+	if cfg.gzipHash && strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		if hashed, err := newGzipHashedBody(body); err == nil {
+			recording.Body = hashed
+		}
+	}
+	recording.Headers = response.Header
+	recording.Headers.Set("Content-Length", strconv.Itoa(length))
+	if cfg.freezeDate {
+		freezeDate(recording.Headers)
+	}
+	if cfg.canonicalVary {
+		TransformHeader("Vary", canonicalVary)(recording)
+	}
+	if cfg.canonicalMultipart {
+		normalizeMultipart(recording)
+	}
+	// a server answers with the protocol of the request
+	httpVersion := fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)
+	recording.HttpVersion = &httpVersion
+	return recording
+}
+
+// truncateBody cuts body to at most maxBytes, without splitting a character, and appends a marker with the number
+// of bytes cut and the hash of the whole body.
+func truncateBody(body string, maxBytes int) string {
+	n := maxBytes
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+	sum := sha256.Sum256([]byte(body))
+	return fmt.Sprintf("%s...[truncated %d bytes, sha256=%s]", body[:n], len(body)-n, hex.EncodeToString(sum[:]))
+}
+
+// isCanonicalJSON reports whether a body with headers h is JSON that is stored in canonical form, with sorted keys
+// and indentation, unless it is stored as the handler wrote it.
+func isCanonicalJSON(h http.Header, body string, cfg *config) bool {
+	var contentType string
+	if h != nil {
+		contentType = h.Get("Content-Type")
+	}
+	return contentType == "application/json" || (cfg.prettyJSON && isJSON(h)) || (cfg.canonicalJSON && looksLikeJSON(body))
+}
+
+// canonicalWireJSON puts the JSON bodies StoreWireJSON records as written into canonical form for comparison.
+func canonicalWireJSON(cfg *config) NormalizeOption {
+	return func(resp *Response) {
+		body, err := resp.Body.decode()
+		if err != nil || !isCanonicalJSON(resp.Headers, body, cfg) {
+			return
+		}
+		body = normalizeJson(body)
+		resp.Body = newBody(body)
+	}
+}
+
+// frozenDate is the Date FreezeDateHeader records.
+var frozenDate = time.Time{}.Format(http.TimeFormat)
+
+// freezeDate replaces the value of the Date header in h, if it has one, with frozenDate.
+func freezeDate(h http.Header) {
+	if h.Get("Date") != "" {
+		h.Set("Date", frozenDate)
+	}
+}
+
+// isRawJSON reports whether r matches a NoJSONNormalize pattern.
+func isRawJSON(r *http.Request, cfg *config) bool {
+	for _, pattern := range cfg.rawJSON {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// serve records the interactions client makes against handler and replaces those in tape that have changed,
+// returning the number of interactions that changed
+func serve(t *testing.T, handler http.Handler, client func(baseURL string), tape *Cassette, cfg *config) (changed int) {
+	t.Helper()
+	cfg = withCassetteOptions(cfg, tape)
+	if cfg.recordedWith != "" {
+		checkRecordedWith(t, tape, cfg.recordedWith)
+	}
+
+	var mu sync.Mutex
+	var recorded []*Interaction
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+
+		next := &Interaction{Response: newRecording(r, recorder, cfg)}
+		next.Request.Method = strings.ToLower(r.Method)
+		next.Request.URI = "http://localhost" + r.URL.RequestURI()
+		if cfg.canonicalURI {
+			next.Request.URI = canonicalURI(next.Request.URI)
+		}
+		next.Request.Headers = r.Header.Clone()
+		// cookies are easier to read, and to edit, one by one
+		if next.Request.Cookies = recordedCookies(r); next.Request.Cookies != nil {
+			next.Request.Headers.Del("Cookie")
+		}
+		if len(body) > 0 {
+			requestBody := newBody(string(body))
+			next.Request.Body = &requestBody
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		recorded = append(recorded, next)
+	}))
+
+	client(server.URL)
+	server.Close()
+
+	isModified := newComparison(cfg)
+	for i, next := range recorded {
+		// keep the existing recording where nothing has changed to reduce the noise in diffs
+		if i < len(tape.Interactions) {
+			previous := tape.Interactions[i]
+			if cfg.canonicalURI {
+				previous.Request.URI = canonicalURI(previous.Request.URI)
+			}
+			if reflect.DeepEqual(previous.Request, next.Request) && !isModified(previous.Response, next.Response) {
+				recorded[i] = previous
+				continue
+			}
 		}
+		next.RecordedAt = time.Now().UTC().Format(http.TimeFormat)
+		if cfg.recordedBy {
+			next.RecordedBy = testPath(t, cfg)
+		}
+		changed++
+	}
+
+	// interactions the client no longer makes are changes too
+	if len(tape.Interactions) > len(recorded) {
+		changed += len(tape.Interactions) - len(recorded)
+	}
+
+	tape.Interactions = recorded
+	return changed
+}
+
+func isResponseModified(before *Response, after *Response, cfg *config) bool {
+	return isDifferent(comparable(before, cfg, nil), comparable(after, cfg, nil), cfg)
+}
 
-		// reduce the noise in diffs by only updating the timestamp of things
-		// that have changed
-		if isResponseModified(interaction.Response, recording, opts) {
-			interaction.Response = recording
-			interaction.RecordedAt = time.Now().UTC().Format(http.TimeFormat)
+// newComparison returns a function that reports whether the responses of successive interactions in a
+// cassette were modified. Cassette-wide normalizers, such as StableIDs, keep their state between calls.
+func newComparison(cfg *config) func(before *Response, after *Response) bool {
+	recorded, actual := stableIDs(cfg.stableIDs), stableIDs(cfg.stableIDs)
+	return func(before *Response, after *Response) bool {
+		return isDifferent(comparable(before, cfg, recorded), comparable(after, cfg, actual), cfg)
+	}
+}
+
+// isDifferent reports whether the comparable forms of a recorded and an actual response differ. With
+// HeaderSubset, headers that only the actual response has are ignored, and headers within their
+// NumericHeaderTolerance or required by RequireHeaderPresent in both are taken to be the same, as are bodies
+// that a comparer registered for the Content-Type accepts.
+func isDifferent(recorded *Response, actual *Response, cfg *config) bool {
+	if cfg.headerSubset && recorded != nil && actual != nil {
+		for name := range actual.Headers {
+			if _, ok := recorded.Headers[name]; !ok {
+				delete(actual.Headers, name)
+			}
+		}
+	}
+	if recorded != nil && actual != nil {
+		for name, delta := range cfg.headerTolerance {
+			if withinTolerance(recorded.Headers[name], actual.Headers[name], delta) {
+				actual.Headers[name] = recorded.Headers[name]
+			}
+		}
+		for _, name := range cfg.requiredHeaders {
+			_, before := recorded.Headers[name]
+			if _, after := actual.Headers[name]; before && after {
+				actual.Headers[name] = recorded.Headers[name]
+			}
+		}
+		if sameBody(recorded, actual) {
+			actual.Body = recorded.Body
+			if _, ok := recorded.Headers["Content-Length"]; ok {
+				actual.Headers["Content-Length"] = recorded.Headers["Content-Length"]
+			}
 		}
 	}
+	return !reflect.DeepEqual(recorded, actual)
 }
 
-func isResponseModified(before *Response, after *Response, opts []NormalizeOption) bool {
-	return !reflect.DeepEqual(normalize(before, opts), normalize(after, opts))
+// sameBody reports whether a comparer registered for the Content-Type of both responses accepts their bodies.
+func sameBody(recorded, actual *Response) bool {
+	if recorded.Headers.Get("Content-Type") != actual.Headers.Get("Content-Type") {
+		return false
+	}
+	compare := registeredComparer(actual.Headers)
+	if compare == nil {
+		return false
+	}
+	before, err := recorded.Body.decode()
+	if err != nil {
+		return false
+	}
+	after, err := actual.Body.decode()
+	if err != nil {
+		return false
+	}
+	return compare(before, after)
+}
+
+// comparable normalizes response into the form that is compared, applying extra after the configured options
+func comparable(response *Response, cfg *config, extra []NormalizeOption) *Response {
+	normalizers := slices.Clone(cfg.normalizers)
+	if cfg.wireJSON {
+		normalizers = append([]NormalizeOption{canonicalWireJSON(cfg)}, normalizers...)
+	}
+	original := response
+	response = Normalize(response, append(normalizers, extra...)...)
+	if response == nil {
+		return nil
+	}
+	if length, changed := normalizedLength(original, response); changed && response.Headers.Get("Content-Length") != "" {
+		// a body the normalizers rewrote is compared with the length of what it became
+		response.Headers.Set("Content-Length", strconv.Itoa(length))
+	}
+	// chunks are metadata about how the body was written and are never compared
+	response.Chunks = nil
+	// where the body is stored does not affect its contents
+	response.Body.File = ""
+	response.Body.compressed = false
+	response.Body.base64URL = false
+	if !cfg.orderedHeaders {
+		response.HeaderOrder = nil
+	}
+	if !cfg.capture1xx {
+		response.Informational = nil
+	}
+	if !cfg.compareHTTPVersion {
+		// the version is recorded for reference but only compared when asked for
+		response.HttpVersion = nil
+	}
+	if cfg.terminalRedirects && isRedirect(response) {
+		// only the target of a redirect matters, not the page some handlers write alongside it
+		response.Body = newBody("")
+		response.Headers.Del("Content-Length")
+	}
+	return response
+}
+
+// normalizedLength returns the length of the body of after, and whether normalizing before into after changed it.
+func normalizedLength(before, after *Response) (int, bool) {
+	body, err := after.Body.decode()
+	if err != nil {
+		return 0, false
+	}
+	original, err := before.Body.decode()
+	return len(body), err != nil || original != body
 }
 
 func findModuleRoot(dir string) (roots string) {
@@ -175,9 +735,13 @@ func findModuleRoot(dir string) (roots string) {
 	return ""
 }
 
+// MaxTestSearchDepth is the number of stack frames searched for the calling test.
 var MaxTestSearchDepth = 20
 
-func findTest(t *testing.T) string {
+// findTest returns the path of the test file calling into the package relative to the module root, which
+// is discovered from go.mod unless root is given. If the test cannot be located its base name is used,
+// or "an unknown test" if there is no test file in the stack at all.
+func findTest(t *testing.T, root string) string {
 	t.Helper()
 	rpc := make([]uintptr, MaxTestSearchDepth)
 	size := runtime.Callers(0, rpc)
@@ -189,19 +753,90 @@ func findTest(t *testing.T) string {
 		frame, more := iter.Next()
 
 		if strings.HasSuffix(filepath.Base(frame.File), "_test.go") {
-			root := findModuleRoot(filepath.Dir(frame.File))
+			if root == "" {
+				root = findModuleRoot(filepath.Dir(frame.File))
+			} else if abs, err := filepath.Abs(root); err == nil {
+				root = abs
+			}
 
 			path, err := filepath.Rel(root, frame.File)
-			require.NoError(t, err)
-			return path
+			if root == "" || err != nil || strings.HasPrefix(path, "..") {
+				return filepath.Base(frame.File)
+			}
+			return filepath.ToSlash(path)
 		}
 
-		require.True(t, more, "no test found within %d stack frames", MaxTestSearchDepth)
+		if !more {
+			t.Logf("no test found within %d stack frames", MaxTestSearchDepth)
+			return "an unknown test"
+		}
 	}
 }
 
+// testPath returns the test to credit with changes to a cassette, set WithTestPath or found with findTest.
+func testPath(t *testing.T, cfg *config) string {
+	t.Helper()
+	if cfg.testPath != "" {
+		return cfg.testPath
+	}
+	return findTest(t, cfg.moduleRoot)
+}
+
+// tempSuffix is appended to the name of a cassette while it is being overwritten.
+const tempSuffix = ".tmp"
+
+// CleanTemp removes the temporary files left behind in dir, and its subdirectories, by overwrite runs
+// that failed or were interrupted. Only files that sit next to the cassette they were created for are removed.
+func CleanTemp(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, tempSuffix) {
+			return err
+		}
+		if fi, err := os.Stat(strings.TrimSuffix(path, tempSuffix)); err != nil || fi.IsDir() {
+			return nil
+		}
+		return os.Remove(path)
+	})
+}
+
+// createTemp creates the file that replaces the cassette at path once it has been written, and writes
+// the banner signposting which test generated it.
+func createTemp(t *testing.T, path string, cfg *config) *os.File {
+	t.Helper()
+
+	// create a separate file and atomically move it into place
+	// leave the file if anything goes wrong so that the user can inspect the result
+	tmp, err := os.Create(path + tempSuffix)
+	require.NoError(t, err)
+
+	// the name is stable so a re-run replaces anything left behind by an earlier failure
+	t.Cleanup(func() {
+		if !t.Failed() {
+			_ = os.Remove(tmp.Name())
+		}
+	})
+
+	// signpost how this cassette was updated with a callback
+	test := testPath(t, cfg)
+
+	banner := fmt.Sprintf("generated by %s", test)
+	if cfg.banner != nil {
+		banner = cfg.banner(test)
+	}
+	if banner != "" {
+		for _, line := range strings.Split(banner, "\n") {
+			_, err = fmt.Fprintf(tmp, "# %s\n", line)
+			require.NoError(t, err)
+		}
+	}
+	_, err = fmt.Fprint(tmp, "---\n")
+	require.NoError(t, err)
+
+	return tmp
+}
+
 // overwriteTape loads the cassette at name and then replaces it after any modifications have been performed by fn
-func overwriteTape(t *testing.T, path string, handler http.Handler, opts []NormalizeOption) {
+func overwriteTape(t *testing.T, path string, cfg *config, fn func(tape *Cassette) int) {
 	t.Helper()
 
 	fd, err := os.Open(path)
@@ -210,24 +845,48 @@ func overwriteTape(t *testing.T, path string, handler http.Handler, opts []Norma
 		require.NoError(t, fd.Close())
 	}()
 
-	// create a separate file and atomically move it into place
-	// leave the file if anything goes wrong so that the user can inspect the result
-	tmp, err := os.Create(fd.Name() + ".tmp")
-	require.NoError(t, err)
+	tmp := createTemp(t, fd.Name(), cfg)
 	defer tmp.Close()
 
-	// signpost how this cassette was updated with a callback
-	test := findTest(t)
-
-	_, err = fmt.Fprintf(tmp, "# generated by %s\n---\n", test)
+	contents, err := io.ReadAll(fd)
 	require.NoError(t, err)
 
-	tape, err := open(fd)
+	tape, err := open(bytes.NewReader(contents))
 	require.NoError(t, err)
+	require.NoError(t, readBodies(filepath.Dir(path), tape))
 
-	replay(t, handler, tape, opts)
+	// keep the comments so that annotations written by hand are not lost, unless starting afresh
+	var original *yaml.Node
+	if !cfg.regenerate {
+		original = &yaml.Node{}
+		require.NoError(t, yaml.Unmarshal(stripBanner(contents), original))
+	}
+
+	// decode a second copy for the hooks to compare against, as fn modifies tape in place
+	var before *Cassette
+	if len(cfg.beforeOverwrite) > 0 {
+		before, err = open(bytes.NewReader(contents))
+		require.NoError(t, err)
+		require.NoError(t, readBodies(filepath.Dir(path), before))
+	}
+
+	addToReport(t, path, fn(tape))
+
+	if cfg.sortInteractions {
+		sortInteractions(tape)
+	}
+
+	for _, hook := range cfg.beforeOverwrite {
+		require.NoErrorf(t, hook(path, before, tape), "refusing to overwrite %s", path)
+	}
 
-	err = encode(tmp, tape)
+	if len(cfg.secretPatterns) > 0 {
+		checkSecrets(t, path, tape, cfg.secretPatterns)
+	}
+
+	require.NoError(t, writeBodies(filepath.Dir(path), tape, cfg))
+
+	err = encodeWithComments(tmp, tape, original)
 	require.NoError(t, err)
 
 	err = tmp.Close()
@@ -237,7 +896,7 @@ func overwriteTape(t *testing.T, path string, handler http.Handler, opts []Norma
 }
 
 // diffTape loads the tape and returns an error if it was modified by fn
-func diffTape(t *testing.T, path string, handler http.Handler, opts []NormalizeOption) {
+func diffTape(t *testing.T, path string, cfg *config, fn func(tape *Cassette) int) {
 	t.Helper()
 	fd, err := os.Open(path)
 	require.NoError(t, err)
@@ -246,17 +905,39 @@ func diffTape(t *testing.T, path string, handler http.Handler, opts []NormalizeO
 		require.NoError(t, fd.Close())
 	}()
 
-	var before bytes.Buffer
-	var after bytes.Buffer
-
 	tape, err := open(fd)
 	require.NoError(t, err)
+	require.NoError(t, readBodies(filepath.Dir(path), tape))
+
+	requireUnchanged(t, tape, cfg, func(tape *Cassette) int {
+		changed := fn(tape)
+		addToReport(t, path, changed)
+		return changed
+	})
+}
+
+// requireUnchanged fails if tape is modified by fn, listing the interactions whose responses changed
+func requireUnchanged(t *testing.T, tape *Cassette, cfg *config, fn func(tape *Cassette) int) {
+	t.Helper()
+
+	var before bytes.Buffer
+	var after bytes.Buffer
 
 	// re-encode to ignore comments or any formatting differences
-	err = encode(&before, tape)
+	err := encode(&before, tape)
 	require.NoError(t, err)
 
-	replay(t, handler, tape, opts)
+	// replay replaces the response of each interaction that changed
+	recorded := make([]*Response, len(tape.Interactions))
+	for i, interaction := range tape.Interactions {
+		recorded[i] = interaction.Response
+	}
+
+	fn(tape)
+
+	if changes := describeChanges(tape, recorded, cfg); len(changes) > 0 {
+		require.Failf(t, "cassette has changed", "%d of %d interactions changed: %s. run this test with the -overwrite flag and commit the result if this change looks legitimate %s", len(changes), len(tape.Interactions), strings.Join(changes, ", "), os.Args[0])
+	}
 
 	err = encode(&after, tape)
 	require.NoError(t, err)
@@ -264,18 +945,120 @@ func diffTape(t *testing.T, path string, handler http.Handler, opts []NormalizeO
 	require.Equal(t, before.String(), after.String(), "cassette has changed. run this test with the -overwrite flag and commit the result if this change looks legitimate", os.Args[0])
 }
 
-var overwrite = flag.Bool("overwrite", false, "Overwrite existing cassettes")
+// tapeLocks holds a *sync.Mutex for each cassette path so that parallel tests do not read a cassette
+// while another is overwriting it.
+var tapeLocks sync.Map
+
+// lockTape blocks until no other replay is using the cassette at path and returns a function that releases it.
+func lockTape(path string) func() {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	mu, _ := tapeLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// overwriteFlag names the flag that makes Replay rewrite cassettes instead of comparing against them.
+const overwriteFlag = "overwrite"
+
+var registerOverwrite sync.Once
+
+func init() {
+	defineOverwriteFlag()
+}
+
+// defineOverwriteFlag registers -overwrite unless another package linked into the same test binary already has,
+// in which case its value is shared.
+func defineOverwriteFlag() {
+	registerOverwrite.Do(func() {
+		if flag.Lookup(overwriteFlag) == nil {
+			flag.Bool(overwriteFlag, false, "Overwrite existing cassettes")
+		}
+	})
+}
+
+// overwriting reports whether the test binary was run with -overwrite.
+func overwriting() bool {
+	defineOverwriteFlag()
+	f := flag.Lookup(overwriteFlag)
+	if f == nil {
+		return false
+	}
+	if getter, ok := f.Value.(flag.Getter); ok {
+		value, _ := getter.Get().(bool)
+		return value
+	}
+	value, _ := strconv.ParseBool(f.Value.String())
+	return value
+}
 
 type NormalizeOption func(*Response)
 
-func Replay(t *testing.T, name string, handler http.Handler, opts ...NormalizeOption) {
+func (o NormalizeOption) apply(c *config) {
+	c.normalizers = append(c.normalizers, o)
+}
+
+func Replay(t *testing.T, name string, handler http.Handler, opts ...Option) {
 	t.Helper()
 
 	fn := diffTape
 
-	if *overwrite {
+	if overwriting() {
 		fn = overwriteTape
 	}
 
-	fn(t, name, handler, opts)
+	cfg := newConfig(opts)
+	handler = withMiddleware(handler, cfg)
+
+	defer lockTape(name)()
+
+	if cfg.streaming {
+		streamTape(t, name, handler, cfg, overwriting())
+		return
+	}
+
+	fn(t, name, cfg, func(tape *Cassette) int {
+		return replay(t, handler, tape, cfg)
+	})
+}
+
+// ReplayReader replays the cassette read from r against handler like Replay, for cassettes that are not stored
+// in a file. The cassette cannot be updated so -overwrite has no effect, and external bodies are read relative
+// to the working directory.
+func ReplayReader(t *testing.T, r io.Reader, handler http.Handler, opts ...Option) {
+	t.Helper()
+
+	cfg := newConfig(opts)
+	handler = withMiddleware(handler, cfg)
+
+	tape, err := open(r)
+	require.NoError(t, err)
+	require.NoError(t, readBodies(".", tape))
+
+	requireUnchanged(t, tape, cfg, func(tape *Cassette) int {
+		return replay(t, handler, tape, cfg)
+	})
+}
+
+// ReplayServer starts a test server running handler and calls client with its URL so that a real client can
+// issue the requests over a socket. The interactions the server sees are then compared against the cassette
+// at name, or written to it when run with -overwrite.
+func ReplayServer(t *testing.T, name string, handler http.Handler, client func(baseURL string), opts ...Option) {
+	t.Helper()
+
+	fn := diffTape
+
+	if overwriting() {
+		fn = overwriteTape
+	}
+
+	cfg := newConfig(opts)
+	handler = withMiddleware(handler, cfg)
+
+	defer lockTape(name)()
+
+	fn(t, name, cfg, func(tape *Cassette) int {
+		return serve(t, handler, client, tape, cfg)
+	})
 }
@@ -0,0 +1,36 @@
+package vcr
+
+import (
+	"flag"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestDefineOverwriteFlag(t *testing.T) {
+	commandLine := flag.CommandLine
+	defer func() {
+		flag.CommandLine = commandLine
+		registerOverwrite = sync.Once{}
+	}()
+
+	// another package linked into the test binary registered -overwrite first
+	flag.CommandLine = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flag.CommandLine.Bool(overwriteFlag, true, "Overwrite existing cassettes")
+	registerOverwrite = sync.Once{}
+
+	require.NotPanics(t, defineOverwriteFlag)
+	require.True(t, overwriting())
+	require.NoError(t, flag.Set(overwriteFlag, "false"))
+	require.False(t, overwriting())
+
+	// with nobody else to define it, -overwrite is registered once even if the package is asked to again
+	flag.CommandLine = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	registerOverwrite = sync.Once{}
+
+	require.NotPanics(t, defineOverwriteFlag)
+	registerOverwrite = sync.Once{}
+	require.NotPanics(t, defineOverwriteFlag)
+	require.NotNil(t, flag.Lookup(overwriteFlag))
+	require.False(t, overwriting())
+}
@@ -0,0 +1,109 @@
+package vcr
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyAll checks that every cassette under dir is current, so that a suite can be gated on all of its
+// cassettes at once, for example from TestMain. Each .yml or .yaml file is replayed against the handler resolve
+// returns for its path, or skipped if resolve returns nil, and every interaction whose response has changed is
+// reported in the returned error with a diff. Responses are compared with opts as Replay would, but the checks
+// that fail a replay outright, such as NoNewHeaders, are not run and -overwrite has no effect.
+func VerifyAll(dir string, resolve func(path string) http.Handler, opts ...Option) error {
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !isCassette(path) {
+			return nil
+		}
+		handler := resolve(path)
+		if handler == nil {
+			return nil
+		}
+		if err := verify(path, handler, newConfig(opts)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// verify replays the cassette at path against handler and returns an error describing each interaction that
+// no longer matches.
+func verify(path string, handler http.Handler, cfg *config) error {
+	handler = withMiddleware(handler, cfg)
+
+	defer lockTape(path)()
+
+	tape, err := Load(path)
+	if err != nil {
+		return err
+	}
+	cfg = withCassetteOptions(cfg, tape)
+
+	isModified := newComparison(cfg)
+	var errs []error
+	for i, interaction := range tape.Interactions {
+		if !isSelected(interaction, cfg) {
+			continue
+		}
+		request, err := newRequest(interaction, cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("interaction %d: %w", i, err))
+			continue
+		}
+		handler := handler
+		if cfg.freshHandler != nil {
+			handler = withMiddleware(cfg.freshHandler(), cfg)
+		}
+		recording, err := verifyInteraction(handler, withInteraction(request, i, interaction), cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("interaction %d (%s %s): %w", i, request.Method, request.URL, err))
+			continue
+		}
+		if isModified(interaction.Response, recording) {
+			errs = append(errs, fmt.Errorf("interaction %d (%s %s) has changed:\n%s", i, request.Method, request.URL, diffResponses(interaction.Response, recording, cfg)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyInteraction serves request with handler, returning the response as it would be recorded or an error
+// if the handler panicked.
+func verifyInteraction(handler http.Handler, request *http.Request, cfg *config) (recording *Response, err error) {
+	request, cancel := withDeadline(request, cfg)
+	defer cancel()
+
+	defer func() {
+		if panicked := recover(); panicked != nil {
+			err = fmt.Errorf("handler panicked: %v", panicked)
+		}
+	}()
+
+	recorder := httptest.NewRecorder()
+	informational := &informationalRecorder{ResponseWriter: recorder}
+	handler.ServeHTTP(informational, request)
+
+	recording = newRecording(request, recorder, cfg)
+	if cfg.capture1xx {
+		recording.Informational = informational.responses
+	}
+	return recording, nil
+}
+
+// isCassette reports whether path names a cassette rather than an external body or other file.
+func isCassette(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yml" || ext == ".yaml"
+}
@@ -0,0 +1,40 @@
+package vcr_test
+
+import (
+	"github.com/simon-engledew/go-vcr"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyAll(t *testing.T) {
+	dir := t.TempDir()
+	contents, err := os.ReadFile("vcr_test.yml")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.yml"), contents, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unused.yml"), []byte("not a cassette"), 0o644))
+
+	hello := http.NewServeMux()
+	hello.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Hello world!", 200)
+	})
+	resolve := func(handler http.Handler) func(path string) http.Handler {
+		return func(path string) http.Handler {
+			if filepath.Base(path) == "hello.yml" {
+				return handler
+			}
+			return nil
+		}
+	}
+	require.NoError(t, vcr.VerifyAll(dir, resolve(hello)))
+
+	goodbye := http.NewServeMux()
+	goodbye.HandleFunc("/hello-world", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Goodbye world!", 200)
+	})
+	err = vcr.VerifyAll(dir, resolve(goodbye))
+	require.ErrorContains(t, err, "hello.yml: interaction 0 (GET http://localhost/hello-world) has changed")
+	require.ErrorContains(t, err, "+        Goodbye world!")
+}